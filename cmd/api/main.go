@@ -10,9 +10,10 @@ import (
 	"time"
 
 	"game_tcpserver/internal/server"
+	"game_tcpserver/internal/tcp"
 )
 
-func gracefulShutdown(apiServer *http.Server, done chan bool) {
+func gracefulShutdown(apiServer, adminServer *http.Server, gameServer *tcp.Server, done chan bool) {
 	// Create context that listens for the interrupt signal from the OS.
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -24,13 +25,22 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	log.Println("shutting down gracefully, press Ctrl+C again to force")
 	stop() // Allow Ctrl+C to force shutdown
 
-	// The context is used to inform the server it has 5 seconds to finish
-	// the request it is currently handling
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// The context is used to inform the servers they have 10 seconds to
+	// finish the requests and game commands they are currently handling.
+	// The game server goes first so /ws sessions are closed by it.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	if err := gameServer.Shutdown(ctx); err != nil {
+		log.Printf("Game server forced to shutdown with error: %v", err)
+	}
 	if err := apiServer.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown with error: %v", err)
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Printf("Admin server forced to shutdown with error: %v", err)
+		}
+	}
 
 	log.Println("Server exiting")
 
@@ -40,13 +50,23 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 
 func main() {
 
-	server := server.NewServer()
+	adminServer := server.NewAdminServer()
+	server, gameServer := server.NewServer()
+
+	// Metrics are served on their own internal listener
+	if adminServer != nil {
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("admin server error: %v", err)
+			}
+		}()
+	}
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
 
 	// Run graceful shutdown in a separate goroutine
-	go gracefulShutdown(server, done)
+	go gracefulShutdown(server, adminServer, gameServer, done)
 
 	err := server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
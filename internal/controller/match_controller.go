@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/service"
+	"game_tcpserver/internal/utils"
+)
+
+type MatchController struct {
+	matchService *service.MatchService
+}
+
+func NewMatchController(matchService *service.MatchService) *MatchController {
+	return &MatchController{
+		matchService: matchService,
+	}
+}
+
+// ListMatchesHandler returns a page of a user's recent matches. The page
+// is chosen with the page (from 1) and limit query parameters.
+func (controller *MatchController) ListMatchesHandler(c *gin.Context) {
+	userID := c.Param("userId")
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	matches, total, err := controller.matchService.ListMatches(userID, page, limit)
+	if err != nil {
+		var customErr *utils.CustomError
+		if errors.As(err, &customErr) {
+			c.JSON(customErr.HTTPStatusCode, gin.H{"error": customErr.Message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load matches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matches": matches,
+		"page":    page,
+		"limit":   limit,
+		"total":   total,
+	})
+}
+
+// GetMatchHandler returns one match in detail.
+func (controller *MatchController) GetMatchHandler(c *gin.Context) {
+	matchID, err := primitive.ObjectIDFromHex(c.Param("matchId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid match ID"})
+		return
+	}
+
+	match, err := controller.matchService.GetMatch(matchID)
+	if err != nil {
+		var customErr *utils.CustomError
+		if errors.As(err, &customErr) {
+			c.JSON(customErr.HTTPStatusCode, gin.H{"error": customErr.Message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load match"})
+		return
+	}
+
+	c.JSON(http.StatusOK, match)
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/service"
+)
+
+type RatingController struct {
+	ratingService *service.RatingService
+}
+
+func NewRatingController(ratingService *service.RatingService) *RatingController {
+	return &RatingController{
+		ratingService: ratingService,
+	}
+}
+
+// ListRatingsHandler returns a user's ratings in every mode they played.
+func (controller *RatingController) ListRatingsHandler(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	ratings, err := controller.ratingService.ListRatings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ratings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ratings": ratings})
+}
+
+// GetRatingHandler returns a user's rating in one game mode.
+func (controller *RatingController) GetRatingHandler(c *gin.Context) {
+	userID, err := primitive.ObjectIDFromHex(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	rating, err := controller.ratingService.GetRating(userID, c.Param("mode"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load rating"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rating)
+}
@@ -0,0 +1,155 @@
+package game
+
+import (
+	"math"
+	"time"
+)
+
+// Events pushed to room members with Conn.SendEvent.
+const (
+	// EventPlayerKilled feeds the kill feed.
+	EventPlayerKilled    = "room.kill"
+	EventPlayerRespawned = "room.respawn"
+)
+
+// SpawnPoint is a place players respawn at.
+type SpawnPoint struct {
+	X, Y float64
+}
+
+// Kill is broadcast to room members when a player dies.
+type Kill struct {
+	RoomID string `json:"roomId"`
+	// KillerID is empty for deaths to server-side damage.
+	KillerID string `json:"killerId,omitempty"`
+	VictimID string `json:"victimId"`
+	Tick     uint64 `json:"tick"`
+	// RespawnAt is when the victim comes back, in unix milliseconds; 0
+	// when respawning is disabled.
+	RespawnAt int64 `json:"respawnAt,omitempty"`
+}
+
+// Respawn is broadcast to room members when a dead player comes back.
+type Respawn struct {
+	RoomID   string  `json:"roomId"`
+	PlayerID string  `json:"playerId"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Tick     uint64  `json:"tick"`
+}
+
+// damage deals amount to target and handles its death: the killer, if
+// any, is credited, the kill is broadcast and the respawn scheduled. The
+// damage that landed counts towards both players' stats. It reports
+// whether target died.
+func (room *GameRoom) damage(killer, target *Player, amount int, now time.Time) bool {
+	if target.Health <= 0 {
+		return false
+	}
+	dealt := amount
+	if dealt > target.Health {
+		dealt = target.Health
+	}
+	target.damageTaken += dealt
+	if killer != nil && killer != target {
+		killer.damageDealt += dealt
+	}
+	target.Health -= amount
+	if target.Health > 0 {
+		return false
+	}
+
+	target.Health = 0
+	target.deaths++
+	kill := Kill{RoomID: room.ID, VictimID: target.ID, Tick: room.Tick}
+	if killer != nil && killer != target {
+		killer.kills++
+		kill.KillerID = killer.ID
+	}
+	if room.Rules.RespawnDelay > 0 {
+		target.respawnAt = now.Add(room.Rules.RespawnDelay)
+		kill.RespawnAt = target.respawnAt.UnixMilli()
+	}
+	room.emit(EventPlayerKilled, kill)
+	return true
+}
+
+// respawn brings back the dead players whose respawn delay is over. It
+// runs every tick.
+func (room *GameRoom) respawn(now time.Time) {
+	if room.State != RoomActive {
+		return
+	}
+	for _, p := range room.Players {
+		if p.Health > 0 || p.respawnAt.IsZero() || now.Before(p.respawnAt) {
+			continue
+		}
+
+		spawn := room.spawnPoint(p)
+		p.X, p.Y = spawn.X, spawn.Y
+		p.Health = room.Rules.MaxHealth
+		p.respawnAt = time.Time{}
+		p.lastMoveAt = now
+		p.lastAttackAt = time.Time{}
+		// Rewinding must not see the player travel from where they died.
+		p.history = nil
+
+		room.emit(EventPlayerRespawned, Respawn{RoomID: room.ID, PlayerID: p.ID, X: p.X, Y: p.Y, Tick: room.Tick})
+	}
+}
+
+// spawnPoint picks the spawn point farthest from the closest living
+// opponent of p, or the map centre when the rules list none.
+func (room *GameRoom) spawnPoint(p *Player) SpawnPoint {
+	points := room.Rules.SpawnPoints
+	if len(points) == 0 {
+		return SpawnPoint{X: room.Rules.Width / 2, Y: room.Rules.Height / 2}
+	}
+
+	best, bestDist := points[0], -1.0
+	for _, sp := range points {
+		closest := math.Inf(1)
+		for _, other := range room.Players {
+			if other != p && other.Health > 0 {
+				closest = math.Min(closest, math.Hypot(other.X-sp.X, other.Y-sp.Y))
+			}
+		}
+		if closest > bestDist {
+			best, bestDist = sp, closest
+		}
+	}
+	return best
+}
+
+// rejectDead rejects an input of dead player p, telling the client when
+// it respawns.
+func rejectDead(p *Player, now time.Time, message string) *ActionError {
+	rejection := reject(p, RejectDead, "%s", message)
+	if !p.respawnAt.IsZero() {
+		rejection.RetryAfter = p.respawnAt.Sub(now)
+	}
+	return rejection
+}
+
+// ApplyDamage deals server-side damage, such as from the environment,
+// without the range and cooldown checks of Attack. It only applies while
+// the match is running; deaths count but credit no one.
+func (m *RoomManager) ApplyDamage(roomID, targetID string, amount int) error {
+	var err error
+	if doErr := m.do(roomID, func(room *GameRoom) {
+		target, exists := room.Players[targetID]
+		switch {
+		case !exists:
+			err = ErrPlayerNotFound
+		case room.State != RoomActive:
+			err = ErrMatchNotActive
+		default:
+			now := time.Now()
+			room.damage(nil, target, amount, now)
+			room.evaluate(now)
+		}
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
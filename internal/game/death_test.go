@@ -0,0 +1,85 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKillAndRespawn(t *testing.T) {
+	m, roomID := newTestManager(t, func(rules *Rules) {
+		rules.RespawnDelay = 100 * time.Millisecond
+		rules.SpawnPoints = []SpawnPoint{{X: 10, Y: 10}, {X: 900, Y: 900}}
+	}), "r"
+
+	conn := &recordingConn{events: make(chan interface{}, 64)}
+	a := &Player{ID: "a", Health: 100, X: 0, Y: 0, Conn: conn}
+	b := &Player{ID: "b", Health: 100, X: 30, Y: 40}
+	m.AddPlayer(roomID, a)
+	m.AddPlayer(roomID, b)
+	defer m.RemovePlayer(roomID, a)
+	defer m.RemovePlayer(roomID, b)
+	startMatch(t, m, roomID)
+
+	inRoom(t, m, roomID, func(*GameRoom) { b.Health = DefaultRules.AttackDamage })
+	res, err := attack(m, roomID, "a", 0, "b", time.Time{})
+	if err != nil || res.Target.Health != 0 || res.Player.Kills != 1 || res.Target.Deaths != 1 {
+		t.Fatalf("killing blow: got %+v, %v", res, err)
+	}
+
+	next := func() interface{} {
+		t.Helper()
+		for {
+			select {
+			case ev := <-conn.events:
+				switch ev.(type) {
+				case Kill, Respawn:
+					return ev
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no kill feed event")
+				return nil
+			}
+		}
+	}
+
+	if kill, ok := next().(Kill); !ok || kill.KillerID != "a" || kill.VictimID != "b" || kill.RespawnAt == 0 {
+		t.Fatalf("kill event = %+v", kill)
+	}
+
+	if _, err := move(m, roomID, "b", 0, 30, 50); rejection(t, err).Reason != RejectDead || rejection(t, err).RetryAfter <= 0 {
+		t.Fatalf("dead player moved: %v", err)
+	}
+	inRoom(t, m, roomID, func(*GameRoom) { a.lastAttackAt = time.Time{} })
+	if _, err := attack(m, roomID, "a", 0, "b", time.Time{}); rejection(t, err).Reason != RejectDead {
+		t.Fatalf("dead target hit: %v", err)
+	}
+
+	// b respawns at the spawn point farthest from a.
+	respawn, ok := next().(Respawn)
+	if !ok || respawn.PlayerID != "b" || respawn.X != 900 || respawn.Y != 900 {
+		t.Fatalf("respawn event = %+v", respawn)
+	}
+	inRoom(t, m, roomID, func(room *GameRoom) {
+		if b.Health != DefaultRules.MaxHealth || b.X != 900 || a.kills != 1 || b.deaths != 1 ||
+			a.damageDealt != DefaultRules.AttackDamage || b.damageTaken != DefaultRules.AttackDamage {
+			t.Errorf("after respawn: a=%+v b=%+v", a.State(), b.State())
+		}
+		if room.State != RoomActive {
+			t.Errorf("match ended on a death with respawns on: %s", room.State)
+		}
+	})
+}
+
+func TestWinnerByKills(t *testing.T) {
+	room := &GameRoom{ID: "r", Players: map[string]*Player{}, State: RoomActive, Rules: DefaultRules}
+	room.Rules.RespawnDelay = time.Second
+	room.Players["a"] = &Player{ID: "a", kills: 3}
+	room.Players["b"] = &Player{ID: "b", kills: 5}
+	if got := room.winner(); got != "b" {
+		t.Errorf("winner = %q, want b", got)
+	}
+	room.Players["c"] = &Player{ID: "c", kills: 5}
+	if got := room.winner(); got != "" {
+		t.Errorf("tie: winner = %q, want none", got)
+	}
+}
@@ -0,0 +1,136 @@
+package game
+
+// RoomDelta is a snapshot encoded against an older one the client has
+// acknowledged, BaseTick. It only lists the players whose position,
+// health, ready flag or score changed, joined or left since then.
+type RoomDelta struct {
+	RoomID     string        `json:"roomId"`
+	Tick       uint64        `json:"tick"`
+	BaseTick   uint64        `json:"baseTick"`
+	ServerTime int64         `json:"serverTime"`
+	State      RoomState     `json:"state,omitempty"` // set when it changed
+	Changed    []PlayerDelta `json:"changed,omitempty"`
+	Joined     []PlayerState `json:"joined,omitempty"`
+	Left       []string      `json:"left,omitempty"`
+	// LastInputSeq is as in RoomSnapshot.
+	LastInputSeq uint64 `json:"lastInputSeq,omitempty"`
+}
+
+// PlayerDelta holds the fields of a player that changed; unchanged ones
+// are omitted.
+type PlayerDelta struct {
+	ID     string   `json:"playerId"`
+	X      *float64 `json:"x,omitempty"`
+	Y      *float64 `json:"y,omitempty"`
+	Health *int     `json:"health,omitempty"`
+	Ready  *bool    `json:"ready,omitempty"`
+	Kills  *int     `json:"kills,omitempty"`
+	Deaths *int     `json:"deaths,omitempty"`
+}
+
+// diffSnapshots encodes cur against base. Both list players sorted by ID.
+func diffSnapshots(base, cur RoomSnapshot) RoomDelta {
+	delta := RoomDelta{
+		RoomID:     cur.RoomID,
+		Tick:       cur.Tick,
+		BaseTick:   base.Tick,
+		ServerTime: cur.ServerTime,
+	}
+	if cur.State != base.State {
+		delta.State = cur.State
+	}
+
+	i, j := 0, 0
+	for i < len(base.Players) || j < len(cur.Players) {
+		switch {
+		case j == len(cur.Players) || (i < len(base.Players) && base.Players[i].ID < cur.Players[j].ID):
+			delta.Left = append(delta.Left, base.Players[i].ID)
+			i++
+		case i == len(base.Players) || cur.Players[j].ID < base.Players[i].ID:
+			delta.Joined = append(delta.Joined, cur.Players[j])
+			j++
+		default:
+			if d, changed := diffPlayer(base.Players[i], cur.Players[j]); changed {
+				delta.Changed = append(delta.Changed, d)
+			}
+			i++
+			j++
+		}
+	}
+	return delta
+}
+
+func diffPlayer(base, cur PlayerState) (PlayerDelta, bool) {
+	d := PlayerDelta{ID: cur.ID}
+	changed := false
+	if cur.X != base.X {
+		x := cur.X
+		d.X, changed = &x, true
+	}
+	if cur.Y != base.Y {
+		y := cur.Y
+		d.Y, changed = &y, true
+	}
+	if cur.Health != base.Health {
+		health := cur.Health
+		d.Health, changed = &health, true
+	}
+	if cur.Ready != base.Ready {
+		ready := cur.Ready
+		d.Ready, changed = &ready, true
+	}
+	if cur.Kills != base.Kills {
+		kills := cur.Kills
+		d.Kills, changed = &kills, true
+	}
+	if cur.Deaths != base.Deaths {
+		deaths := cur.Deaths
+		d.Deaths, changed = &deaths, true
+	}
+	return d, changed
+}
+
+// snapshotHistory keeps the last snapshots of a room as delta baselines.
+type snapshotHistory struct {
+	snapshots []RoomSnapshot // indexed by tick modulo size
+}
+
+func newSnapshotHistory(size int) *snapshotHistory {
+	return &snapshotHistory{snapshots: make([]RoomSnapshot, size)}
+}
+
+func (h *snapshotHistory) add(s RoomSnapshot) {
+	if len(h.snapshots) == 0 {
+		return
+	}
+	h.snapshots[s.Tick%uint64(len(h.snapshots))] = s
+}
+
+// at returns the snapshot of tick if it is still kept.
+func (h *snapshotHistory) at(tick uint64) (RoomSnapshot, bool) {
+	if len(h.snapshots) == 0 || tick == 0 {
+		return RoomSnapshot{}, false
+	}
+	s := h.snapshots[tick%uint64(len(h.snapshots))]
+	return s, s.Tick == tick
+}
+
+// AckSnapshot records that playerID received the snapshot of tick, making
+// it the baseline of the player's next deltas. Acks for ticks the room has
+// not reached are ignored, as are acks older than the current baseline.
+func (m *RoomManager) AckSnapshot(roomID, playerID string, tick uint64) error {
+	var err error
+	if doErr := m.do(roomID, func(room *GameRoom) {
+		player, exists := room.Players[playerID]
+		if !exists {
+			err = ErrPlayerNotFound
+			return
+		}
+		if tick <= room.Tick && tick > player.ackedTick {
+			player.ackedTick = tick
+		}
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
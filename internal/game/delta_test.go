@@ -0,0 +1,84 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	base := RoomSnapshot{RoomID: "r", Tick: 3, State: "waiting", Players: []PlayerState{
+		{ID: "a", X: 1, Y: 1, Health: 100},
+		{ID: "b", X: 2, Y: 2, Health: 100},
+		{ID: "c", X: 3, Y: 3, Health: 100},
+		{ID: "e", Ready: true},
+	}}
+	cur := RoomSnapshot{RoomID: "r", Tick: 7, State: "active", Players: []PlayerState{
+		{ID: "a", X: 1, Y: 1, Health: 100}, // unchanged
+		{ID: "c", X: 3, Y: 5, Health: 80},  // moved and hit
+		{ID: "d", X: 4, Y: 4, Health: 100}, // joined; b left
+		{ID: "e", Ready: false},            // no longer ready
+	}}
+
+	d := diffSnapshots(base, cur)
+	if d.Tick != 7 || d.BaseTick != 3 || d.State != "active" {
+		t.Errorf("header = %+v", d)
+	}
+	if len(d.Left) != 1 || d.Left[0] != "b" {
+		t.Errorf("left = %v", d.Left)
+	}
+	if len(d.Joined) != 1 || d.Joined[0].ID != "d" {
+		t.Errorf("joined = %v", d.Joined)
+	}
+	if len(d.Changed) != 2 {
+		t.Fatalf("changed = %+v", d.Changed)
+	}
+	c := d.Changed[0]
+	if c.ID != "c" || c.X != nil || c.Y == nil || *c.Y != 5 || c.Health == nil || *c.Health != 80 || c.Ready != nil {
+		t.Errorf("changed c = %+v", c)
+	}
+	// A cleared flag is sent as an explicit false.
+	if e := d.Changed[1]; e.ID != "e" || e.Ready == nil || *e.Ready || e.Health != nil {
+		t.Errorf("changed e = %+v", e)
+	}
+}
+
+func TestRoomLoopSendsDeltasAfterAck(t *testing.T) {
+	m, roomID := newTestManager(t, nil), "r"
+	conn := &recordingConn{snapshots: make(chan RoomSnapshot, 64), deltas: make(chan RoomDelta, 64)}
+	p := &Player{ID: "p1", Health: 100, Conn: conn}
+	m.AddPlayer(roomID, p)
+	defer m.RemovePlayer(roomID, p)
+
+	var full RoomSnapshot
+	select {
+	case full = <-conn.snapshots:
+	case <-time.After(time.Second):
+		t.Fatal("no full snapshot before the first ack")
+	}
+
+	if err := m.AckSnapshot(roomID, "p1", full.Tick); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-conn.deltas:
+		if d.BaseTick != full.Tick || d.Tick <= full.Tick || len(d.Changed) != 0 {
+			t.Errorf("delta = %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no delta after the ack")
+	}
+
+	// A baseline that fell out of the history gets a full snapshot again.
+	inRoom(t, m, roomID, func(room *GameRoom) {
+		room.history = newSnapshotHistory(1)
+		p.ackedTick = 1
+	})
+	for len(conn.snapshots) > 0 {
+		<-conn.snapshots
+	}
+	select {
+	case <-conn.snapshots:
+	case <-time.After(time.Second):
+		t.Fatal("no full snapshot for a stale baseline")
+	}
+}
@@ -1,21 +1,118 @@
 package game
 
-import (
-	"net"
-	"sync"
-)
+import "time"
+
+// Conn is the client connection a player's events are pushed to. It is
+// transport-agnostic so TCP and WebSocket players can share a room.
+type Conn interface {
+	Send(v interface{}) error
+	// SendEvent pushes a room event, such as a state transition, that the
+	// client must not miss. It must not block.
+	SendEvent(eventType string, data interface{}) error
+	// SendRoomState pushes a tick snapshot. Each snapshot supersedes the
+	// previous one, so it may be dropped under backpressure and is not
+	// replayed after a reconnect. It must not block.
+	SendRoomState(snapshot RoomSnapshot) error
+	// SendRoomDelta pushes a tick encoded against a snapshot the player
+	// acknowledged, under the same rules as SendRoomState.
+	SendRoomDelta(delta RoomDelta) error
+}
 
 type Player struct {
 	ID     string
 	Name   string
 	X, Y   float64
 	Health int
-	Conn   net.Conn
+	Conn   Conn
+
+	ready        bool
+	lastMoveAt   time.Time
+	lastAttackAt time.Time
+	ackedTick    uint64 // baseline for deltas, see AckSnapshot
+	lastInputSeq uint64 // see Input.Seq
+	history      *positionHistory
+
+	// Per-match counters, reset when a match starts.
+	kills, deaths            int
+	damageDealt, damageTaken int
+	respawnAt                time.Time // set while dead and waiting to respawn
 }
 
+// PlayerState is the authoritative view of a player sent to clients.
+type PlayerState struct {
+	ID     string  `json:"playerId"`
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Health int     `json:"health"`
+	Ready  bool    `json:"ready"`
+	Kills  int     `json:"kills"`
+	Deaths int     `json:"deaths"`
+}
+
+// State snapshots p. Only the room goroutine may call it.
+func (p *Player) State() PlayerState {
+	return PlayerState{ID: p.ID, Name: p.Name, X: p.X, Y: p.Y, Health: p.Health, Ready: p.ready, Kills: p.kills, Deaths: p.deaths}
+}
+
+// GameRoom is a room of a RoomManager. It is only touched by its own
+// goroutine, run, which other goroutines hand work to with call.
 type GameRoom struct {
 	ID      string
 	Players map[string]*Player
-	Mu      sync.Mutex
-	State   string // "waiting", "active", "finished"
+	State   RoomState
+	Rules   Rules
+
+	// Tick counts the simulation steps of the room loop, see run.
+	Tick    uint64
+	inputs  []*Input
+	ticker  *time.Ticker // nil while the room is empty
+	history *snapshotHistory
+
+	reserved map[string]bool // the only players who may join, if set
+
+	startedAt time.Time      // start of the current match
+	departed  []PlayerResult // players who left the current match
+
+	deadline time.Time   // when the current state ends by itself, if set
+	pending  []roomEvent // events to send once the command or tick is done
+
+	manager    *RoomManager
+	cmds       chan func()
+	quit       chan struct{} // closed by RoomManager.Close
+	done       chan struct{} // closed when run returns
+	emptySince time.Time     // zero while players are in the room
+	reapTimer  *time.Timer   // see scheduleReap
+	reaped     bool          // removed from the manager; run returns
+}
+
+// RoomResult is a snapshot of a room's outcome, taken when its match
+// ends or when the RoomManager is closed while it is still running.
+type RoomResult struct {
+	RoomID   string
+	Mode     string
+	State    string
+	Reason   string // why the match ended, see RoomTransition
+	WinnerID string
+	// Players are ordered by Placement; those who left during the match
+	// come last.
+	Players   []PlayerResult
+	StartedAt time.Time // zero if the match never started
+	EndedAt   time.Time
+}
+
+type PlayerResult struct {
+	PlayerID string
+	Name     string
+	X, Y     float64
+	Health   int
+	Kills    int
+	Deaths   int
+	// Damage dealt to other players and taken from any source.
+	DamageDealt int
+	DamageTaken int
+	// Placement ranks the player from 1, the winner or top scorer; tied
+	// players share it.
+	Placement int
+	Left      bool // left or was dropped before the match ended
 }
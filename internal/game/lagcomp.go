@@ -0,0 +1,66 @@
+package game
+
+import "time"
+
+type positionSample struct {
+	at   time.Time
+	x, y float64
+}
+
+// positionHistory is a ring buffer of a player's positions at past ticks,
+// used to rewind targets for lag-compensated hits.
+type positionHistory struct {
+	samples []positionSample
+	next    int
+	count   int
+}
+
+func newPositionHistory(size int) *positionHistory {
+	return &positionHistory{samples: make([]positionSample, size)}
+}
+
+func (h *positionHistory) add(s positionSample) {
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % len(h.samples)
+	if h.count < len(h.samples) {
+		h.count++
+	}
+}
+
+// sample returns the i-th newest sample, 0 being the newest.
+func (h *positionHistory) sample(i int) positionSample {
+	return h.samples[(h.next-1-i+2*len(h.samples))%len(h.samples)]
+}
+
+// recordPosition stores p's position at the end of a tick. The history
+// covers MaxRewind plus a tick on either side.
+func (p *Player) recordPosition(now time.Time, rules Rules) {
+	if p.history == nil {
+		size := int(rules.MaxRewind*time.Duration(rules.TickRate)/time.Second) + 2
+		p.history = newPositionHistory(size)
+	}
+	p.history.add(positionSample{at: now, x: p.X, y: p.Y})
+}
+
+// positionAt returns where p was at t, interpolating between the ticks
+// around it. The current position counts as a sample at now; times before
+// the history use its oldest sample.
+func (p *Player) positionAt(t, now time.Time) (x, y float64) {
+	newer := positionSample{at: now, x: p.X, y: p.Y}
+	if p.history == nil || !t.Before(now) {
+		return newer.x, newer.y
+	}
+
+	for i := 0; i < p.history.count; i++ {
+		older := p.history.sample(i)
+		if !older.at.After(t) {
+			if !newer.at.After(older.at) {
+				return older.x, older.y
+			}
+			f := float64(t.Sub(older.at)) / float64(newer.at.Sub(older.at))
+			return older.x + (newer.x-older.x)*f, older.y + (newer.y-older.y)*f
+		}
+		newer = older
+	}
+	return newer.x, newer.y
+}
@@ -0,0 +1,67 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPositionAtInterpolates(t *testing.T) {
+	base := time.Unix(1000, 0)
+	p := &Player{X: 0, Y: 0}
+	rules := Rules{TickRate: 10, MaxRewind: 200 * time.Millisecond}
+
+	for i := 0; i < 5; i++ {
+		p.X = float64(i * 10)
+		p.recordPosition(base.Add(time.Duration(i)*100*time.Millisecond), rules)
+	}
+	// The history holds 4 samples: x = 10, 20, 30, 40 at 100..400ms.
+	p.X = 45
+	now := base.Add(450 * time.Millisecond)
+
+	tests := []struct {
+		at    time.Duration
+		wantX float64
+	}{
+		{at: 450 * time.Millisecond, wantX: 45},   // current position
+		{at: 425 * time.Millisecond, wantX: 42.5}, // between last tick and now
+		{at: 350 * time.Millisecond, wantX: 35},
+		{at: 200 * time.Millisecond, wantX: 20},
+		{at: 0, wantX: 10}, // older than the history
+	}
+	for _, tt := range tests {
+		if x, _ := p.positionAt(base.Add(tt.at), now); x != tt.wantX {
+			t.Errorf("positionAt(%v) = %v, want %v", tt.at, x, tt.wantX)
+		}
+	}
+}
+
+func TestAttackRewindsTarget(t *testing.T) {
+	m, roomID := newTestManager(t, nil), "r"
+	conn := &recordingConn{snapshots: make(chan RoomSnapshot, 64), deltas: make(chan RoomDelta, 64)}
+	a := &Player{ID: "a", Health: 100, X: 0, Y: 0, Conn: conn}
+	b := &Player{ID: "b", Health: 100, X: 30, Y: 40}
+	m.AddPlayer(roomID, a)
+	m.AddPlayer(roomID, b)
+	defer m.RemovePlayer(roomID, a)
+	defer m.RemovePlayer(roomID, b)
+	startMatch(t, m, roomID)
+
+	// The attacker renders a tick with b in range, then b moves away.
+	<-conn.snapshots
+	seen := <-conn.snapshots
+	inRoom(t, m, roomID, func(*GameRoom) { b.lastMoveAt = time.Now().Add(-time.Second) })
+	if _, err := move(m, roomID, "b", 0, 30, 240); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := attack(m, roomID, "a", 0, "b", time.Time{}); rejection(t, err).Reason != RejectOutOfRange {
+		t.Fatal("attack on the current position hit")
+	}
+	res, err := attack(m, roomID, "a", 0, "b", time.UnixMilli(seen.ServerTime))
+	if err != nil {
+		t.Fatalf("attack at the tick b was seen in range: %v", err)
+	}
+	if res.Rewind <= 0 || res.Rewind > DefaultRules.MaxRewind {
+		t.Errorf("rewind = %v", res.Rewind)
+	}
+}
@@ -0,0 +1,287 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RoomState is the phase of a room's lifecycle:
+//
+//	waiting -> countdown -> active -> finished -> waiting
+//	              |
+//	              +-> waiting (countdown cancelled)
+//
+// finished is the post-match phase; once it ends the room is recycled for
+// another match with the players still in it.
+type RoomState string
+
+const (
+	RoomWaiting   RoomState = "waiting"
+	RoomCountdown RoomState = "countdown"
+	RoomActive    RoomState = "active"
+	RoomFinished  RoomState = "finished"
+)
+
+var roomTransitions = map[RoomState][]RoomState{
+	RoomWaiting:   {RoomCountdown},
+	RoomCountdown: {RoomWaiting, RoomActive},
+	RoomActive:    {RoomFinished},
+	RoomFinished:  {RoomWaiting},
+}
+
+// Events pushed to room members with Conn.SendEvent.
+const (
+	EventRoomTransition = "room.transition"
+	EventPlayerReady    = "room.ready"
+	EventPlayerLeft     = "room.leave"
+)
+
+// Reasons reported in RoomTransition.
+const (
+	ReasonAllReady           = "all_ready"
+	ReasonCountdownCancelled = "countdown_cancelled"
+	ReasonCountdownDone      = "countdown_done"
+	ReasonLastStanding       = "last_standing"
+	ReasonTimeLimit          = "time_limit"
+	ReasonNotEnoughPlayers   = "not_enough_players"
+	ReasonRecycled           = "recycled"
+	// ReasonServerShutdown ends the matches still running when the
+	// RoomManager is closed; the rooms make no transition.
+	ReasonServerShutdown = "server_shutdown"
+)
+
+var (
+	ErrRoomFull          = errors.New("room is full")
+	ErrMatchInProgress   = errors.New("a match is in progress in this room")
+	ErrInvalidTransition = errors.New("invalid room state transition")
+	ErrMatchNotActive    = errors.New("the match is not running")
+)
+
+// RoomTransition is broadcast to room members on every state change.
+type RoomTransition struct {
+	RoomID string    `json:"roomId"`
+	From   RoomState `json:"from"`
+	To     RoomState `json:"to"`
+	Reason string    `json:"reason"`
+	Tick   uint64    `json:"tick"`
+	// Deadline is when the new state ends by itself, in unix milliseconds:
+	// the end of the countdown, the match time limit or the post-match.
+	Deadline int64  `json:"deadline,omitempty"`
+	WinnerID string `json:"winnerId,omitempty"`
+}
+
+type PlayerReady struct {
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+	Ready    bool   `json:"ready"`
+}
+
+// roomEvent is an event emitted by a command or tick and sent by flush.
+type roomEvent struct {
+	conns     []Conn
+	eventType string
+	data      interface{}
+}
+
+func (room *GameRoom) conns() []Conn {
+	conns := make([]Conn, 0, len(room.Players))
+	for _, p := range room.Players {
+		if p.Conn != nil {
+			conns = append(conns, p.Conn)
+		}
+	}
+	return conns
+}
+
+// emit queues an event for the current members; it is sent by flush.
+func (room *GameRoom) emit(eventType string, data interface{}) {
+	room.pending = append(room.pending, roomEvent{conns: room.conns(), eventType: eventType, data: data})
+}
+
+// flush sends the events emitted so far. The room goroutine flushes after
+// every command and tick, so events follow the state change that caused
+// them.
+func (room *GameRoom) flush() {
+	pending := room.pending
+	room.pending = nil
+
+	for _, ev := range pending {
+		for _, conn := range ev.conns {
+			conn.SendEvent(ev.eventType, ev.data)
+		}
+	}
+}
+
+// transition moves the room to state to if the lifecycle allows it
+// and broadcasts the change.
+func (room *GameRoom) transition(to RoomState, reason string, now time.Time) error {
+	from := room.State
+	allowed := false
+	for _, next := range roomTransitions[from] {
+		allowed = allowed || next == to
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	room.State = to
+	room.deadline = time.Time{}
+	ev := RoomTransition{RoomID: room.ID, From: from, To: to, Reason: reason, Tick: room.Tick}
+
+	switch to {
+	case RoomCountdown:
+		room.deadline = now.Add(room.Rules.CountdownDuration)
+	case RoomActive:
+		for _, p := range room.Players {
+			p.Health = room.Rules.MaxHealth
+			p.lastAttackAt = time.Time{}
+			p.kills, p.deaths, p.respawnAt = 0, 0, time.Time{}
+			p.damageDealt, p.damageTaken = 0, 0
+		}
+		room.startedAt = now
+		room.departed = nil
+		if room.Rules.MatchDuration > 0 {
+			room.deadline = now.Add(room.Rules.MatchDuration)
+		}
+	case RoomFinished:
+		room.deadline = now.Add(room.Rules.PostMatchDuration)
+		ev.WinnerID = room.winner()
+		result := room.result(now)
+		result.Reason = reason
+		room.manager.matchEnded(result)
+	case RoomWaiting:
+		if from == RoomFinished {
+			for _, p := range room.Players {
+				p.ready = false
+				p.Health = room.Rules.MaxHealth
+				p.respawnAt = time.Time{}
+			}
+		}
+	}
+	if !room.deadline.IsZero() {
+		ev.Deadline = room.deadline.UnixMilli()
+	}
+
+	room.emit(EventRoomTransition, ev)
+	return nil
+}
+
+// evaluate takes the transition whose condition is met, if any. It
+// runs every tick and whenever players join, leave or change their ready
+// flag.
+func (room *GameRoom) evaluate(now time.Time) {
+	switch room.State {
+	case RoomWaiting:
+		if room.lobbyReady() {
+			room.transition(RoomCountdown, ReasonAllReady, now)
+		}
+	case RoomCountdown:
+		if !room.lobbyReady() {
+			room.transition(RoomWaiting, ReasonCountdownCancelled, now)
+		} else if !now.Before(room.deadline) {
+			room.transition(RoomActive, ReasonCountdownDone, now)
+		}
+	case RoomActive:
+		if reason := room.matchOver(now); reason != "" {
+			room.transition(RoomFinished, reason, now)
+		}
+	case RoomFinished:
+		if !now.Before(room.deadline) {
+			room.transition(RoomWaiting, ReasonRecycled, now)
+		}
+	}
+}
+
+// lobbyReady reports whether the match can start: enough players
+// and all of them ready.
+func (room *GameRoom) lobbyReady() bool {
+	n := len(room.Players)
+	if n < room.Rules.MinPlayers || n > room.Rules.MaxPlayers {
+		return false
+	}
+	for _, p := range room.Players {
+		if !p.ready {
+			return false
+		}
+	}
+	return true
+}
+
+// matchOver returns why the match ends, or "" while it goes on.
+func (room *GameRoom) matchOver(now time.Time) string {
+	if !room.deadline.IsZero() && !now.Before(room.deadline) {
+		return ReasonTimeLimit
+	}
+	if len(room.Players) < room.Rules.MinPlayers {
+		return ReasonNotEnoughPlayers
+	}
+
+	// With respawns the match runs to its time limit.
+	if room.Rules.RespawnDelay > 0 {
+		return ""
+	}
+	alive := 0
+	for _, p := range room.Players {
+		if p.Health > 0 {
+			alive++
+		}
+	}
+	if room.Rules.MinPlayers > 1 && alive <= 1 {
+		return ReasonLastStanding
+	}
+	return ""
+}
+
+// winner returns the player with the most kills when players
+// respawn, the only player alive otherwise; "" when there is a tie.
+func (room *GameRoom) winner() string {
+	winner := ""
+	if room.Rules.RespawnDelay > 0 {
+		best := 0
+		for _, p := range room.Players {
+			switch {
+			case p.kills > best:
+				winner, best = p.ID, p.kills
+			case p.kills == best:
+				winner = ""
+			}
+		}
+		return winner
+	}
+
+	for _, p := range room.Players {
+		if p.Health > 0 {
+			if winner != "" {
+				return ""
+			}
+			winner = p.ID
+		}
+	}
+	return winner
+}
+
+// SetReady sets playerID's ready flag in the lobby. The countdown starts
+// once enough players are ready and is cancelled when one un-readies.
+func (m *RoomManager) SetReady(roomID, playerID string, ready bool) error {
+	var err error
+	if doErr := m.do(roomID, func(room *GameRoom) {
+		room.applyInputsOf(playerID, time.Now())
+		player, exists := room.Players[playerID]
+		switch {
+		case !exists:
+			err = ErrPlayerNotFound
+		case room.State != RoomWaiting && room.State != RoomCountdown:
+			err = ErrMatchInProgress
+		default:
+			if player.ready != ready {
+				player.ready = ready
+				room.emit(EventPlayerReady, PlayerReady{RoomID: room.ID, PlayerID: playerID, Ready: ready})
+			}
+			room.evaluate(time.Now())
+		}
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package game
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRoomLifecycle(t *testing.T) {
+	m, roomID := newTestManager(t, func(rules *Rules) {
+		rules.MaxPlayers = 2
+		rules.CountdownDuration = 50 * time.Millisecond
+		rules.PostMatchDuration = 50 * time.Millisecond
+		rules.RespawnDelay = 0
+	}), "r"
+	ended := make(chan RoomResult, 1)
+	m.OnMatchEnd(func(result RoomResult) { ended <- result })
+
+	conn := &recordingConn{events: make(chan interface{}, 64)}
+	a := &Player{ID: "a", Health: 100, Conn: conn}
+	b := &Player{ID: "b", Health: 100}
+	if err := m.AddPlayer(roomID, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddPlayer(roomID, b); err != nil {
+		t.Fatal(err)
+	}
+	defer m.RemovePlayer(roomID, a)
+	defer m.RemovePlayer(roomID, b)
+
+	if err := m.AddPlayer(roomID, &Player{ID: "c"}); !errors.Is(err, ErrRoomFull) {
+		t.Fatalf("third player: got %v, want ErrRoomFull", err)
+	}
+
+	next := func(want RoomState, reason string) RoomTransition {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case ev := <-conn.events:
+				tr, ok := ev.(RoomTransition)
+				if !ok {
+					continue
+				}
+				if tr.To != want || tr.Reason != reason {
+					t.Fatalf("transition = %+v, want to %s (%s)", tr, want, reason)
+				}
+				return tr
+			case <-timeout:
+				t.Fatalf("no transition to %s", want)
+				return RoomTransition{}
+			}
+		}
+	}
+
+	m.SetReady(roomID, "a", true)
+	m.SetReady(roomID, "b", true)
+	next(RoomCountdown, ReasonAllReady)
+
+	m.SetReady(roomID, "b", false)
+	next(RoomWaiting, ReasonCountdownCancelled)
+
+	m.SetReady(roomID, "b", true)
+	next(RoomCountdown, ReasonAllReady)
+	next(RoomActive, ReasonCountdownDone)
+
+	if err := m.SetReady(roomID, "a", false); !errors.Is(err, ErrMatchInProgress) {
+		t.Fatalf("un-ready during the match: got %v", err)
+	}
+	if err := m.AddPlayer(roomID, &Player{ID: "late"}); !errors.Is(err, ErrMatchInProgress) {
+		t.Fatalf("join during the match: got %v", err)
+	}
+
+	m.ApplyDamage(roomID, "b", 1000)
+	if tr := next(RoomFinished, ReasonLastStanding); tr.WinnerID != "a" {
+		t.Errorf("winner = %q, want a", tr.WinnerID)
+	}
+	select {
+	case result := <-ended:
+		if result.Reason != ReasonLastStanding || result.Mode != DefaultRules.Mode || result.StartedAt.IsZero() ||
+			len(result.Players) != 2 || result.Players[0].PlayerID != "a" || result.Players[1].Placement != 2 {
+			t.Errorf("match result = %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("match end not reported")
+	}
+	next(RoomWaiting, ReasonRecycled)
+
+	inRoom(t, m, roomID, func(*GameRoom) {
+		if a.ready || b.ready || b.Health != DefaultRules.MaxHealth {
+			t.Errorf("recycled room kept match state: a=%+v b=%+v", a.State(), b.State())
+		}
+	})
+}
+
+func TestTransitionsAreEnforced(t *testing.T) {
+	room := &GameRoom{ID: "r", Players: map[string]*Player{}, State: RoomWaiting, Rules: DefaultRules}
+	if err := room.transition(RoomActive, "", time.Now()); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("waiting to active: got %v", err)
+	}
+	if err := room.transition(RoomCountdown, ReasonAllReady, time.Now()); err != nil {
+		t.Errorf("waiting to countdown: %v", err)
+	}
+}
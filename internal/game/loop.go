@@ -0,0 +1,267 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type InputKind int
+
+const (
+	InputMove InputKind = iota
+	InputAttack
+)
+
+// Input is a player action queued for the room loop.
+type Input struct {
+	PlayerID string
+	Kind     InputKind
+	// Seq is the client's sequence number for the input; 0 leaves it
+	// unsequenced. Inputs at or below the last processed Seq of the player
+	// are dropped as duplicates or stale.
+	Seq      uint64
+	X, Y     float64 // InputMove
+	TargetID string  // InputAttack
+	// ViewTime is the server time the client was displaying when it
+	// attacked, used to rewind targets; zero disables lag compensation.
+	ViewTime time.Time
+
+	done func(InputResult)
+}
+
+// InputResult is the outcome of an Input once its tick has run.
+type InputResult struct {
+	Player PlayerState
+	Target PlayerState // InputAttack
+	// Rewind is how far back the target was rewound for an attack.
+	Rewind time.Duration
+	Err    error
+}
+
+// RoomSnapshot is the state of a room after a tick, broadcast to every
+// player in it. Clients interpolate between consecutive ticks.
+type RoomSnapshot struct {
+	RoomID     string        `json:"roomId"`
+	Tick       uint64        `json:"tick"`
+	ServerTime int64         `json:"serverTime"` // unix milliseconds
+	State      RoomState     `json:"state"`
+	Players    []PlayerState `json:"players"`
+	// LastInputSeq is the last input of the receiving player applied in
+	// or before this tick, for client-side reconciliation.
+	LastInputSeq uint64 `json:"lastInputSeq,omitempty"`
+}
+
+// newGameRoom returns a room owned by m. Its goroutine is started by the
+// caller.
+func newGameRoom(m *RoomManager, roomID string, rules Rules) *GameRoom {
+	room := &GameRoom{
+		ID:      roomID,
+		Players: make(map[string]*Player),
+		State:   RoomWaiting,
+		Rules:   rules,
+		manager: m,
+		history: newSnapshotHistory(rules.SnapshotHistory),
+		cmds:    make(chan func()),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	room.scheduleReap(time.Now())
+	return room
+}
+
+// run is the room goroutine, the only one that touches the room. It runs
+// the commands sent with call and, while players are in the room, ticks it
+// at a fixed timestep. Ticks it falls behind on are skipped rather than
+// run in a burst. It returns once the room is reaped or closed.
+func (room *GameRoom) run() {
+	defer close(room.done)
+
+	for !room.reaped {
+		var ticks <-chan time.Time
+		if room.ticker != nil {
+			ticks = room.ticker.C
+		}
+
+		select {
+		case cmd := <-room.cmds:
+			cmd()
+		case now := <-ticks:
+			room.tick(now)
+		case <-room.quit:
+			if room.State == RoomActive {
+				result := room.result(time.Now())
+				result.Reason = ReasonServerShutdown
+				room.manager.matchEnded(result)
+			}
+			room.reaped = true
+			playersInRooms.Add(-int64(len(room.Players)))
+			roomsActive.Add(-1)
+		}
+		room.flush()
+	}
+
+	room.stopTicking()
+	if room.reapTimer != nil {
+		room.reapTimer.Stop()
+	}
+}
+
+// call runs fn on the room goroutine and waits for it. It returns
+// ErrRoomNotFound once the room is gone. fn must not call into the room
+// again.
+func (room *GameRoom) call(fn func()) error {
+	done := make(chan struct{})
+	select {
+	case room.cmds <- func() { fn(); close(done) }:
+		<-done
+		return nil
+	case <-room.done:
+		return ErrRoomNotFound
+	}
+}
+
+// submitInput queues in for the room loop without waiting for its tick.
+// done receives the outcome on the room goroutine, so it must neither
+// block nor call into the room.
+func (m *RoomManager) submitInput(roomID string, in *Input, done func(InputResult)) error {
+	in.done = done
+	var err error
+	if doErr := m.do(roomID, func(room *GameRoom) {
+		if _, exists := room.Players[in.PlayerID]; !exists {
+			err = ErrPlayerNotFound
+			return
+		}
+		room.inputs = append(room.inputs, in)
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// startTicking starts the room loop unless it is running.
+func (room *GameRoom) startTicking() {
+	if room.ticker == nil {
+		room.ticker = time.NewTicker(time.Second / time.Duration(room.Rules.TickRate))
+	}
+}
+
+// stopTicking stops the room loop and fails the inputs it did not get to.
+func (room *GameRoom) stopTicking() {
+	if room.ticker == nil {
+		return
+	}
+	room.ticker.Stop()
+	room.ticker = nil
+
+	for _, in := range room.inputs {
+		in.done(InputResult{Err: ErrPlayerNotFound})
+	}
+	room.inputs = nil
+}
+
+// applyInputsOf applies the inputs playerID queued so far, in arrival
+// order. Commands that act on the player's seat right away call it first,
+// so that they come after the inputs sent before them: a move followed by
+// leave_room is applied, not failed because the player has left.
+func (room *GameRoom) applyInputsOf(playerID string, now time.Time) {
+	kept := room.inputs[:0]
+	for _, in := range room.inputs {
+		if in.PlayerID != playerID {
+			kept = append(kept, in)
+			continue
+		}
+		in.done(room.applyInput(in, now))
+	}
+	room.inputs = kept
+}
+
+// tick applies the queued inputs in arrival order, advances the tick
+// counter and sends every player the resulting snapshot, as a delta
+// against the last tick the player acknowledged when that is still kept.
+func (room *GameRoom) tick(now time.Time) {
+	inputs := room.inputs
+	room.inputs = nil
+	for _, in := range inputs {
+		in.done(room.applyInput(in, now))
+	}
+
+	room.Tick++
+	room.respawn(now)
+	for _, p := range room.Players {
+		p.recordPosition(now, room.Rules)
+	}
+	room.evaluate(now)
+	snapshot := room.snapshot(now)
+	room.history.add(snapshot)
+	// Events of this tick are handed to the connections before its
+	// snapshot, but a session may still write the snapshot first, see
+	// tcp.Session.nextLocked.
+	room.flush()
+
+	// Players acknowledging the same tick share one delta.
+	deltas := make(map[uint64]*RoomDelta)
+	for _, p := range room.Players {
+		if p.Conn == nil {
+			continue
+		}
+		base, ok := room.history.at(p.ackedTick)
+		if !ok {
+			full := snapshot
+			full.LastInputSeq = p.lastInputSeq
+			p.Conn.SendRoomState(full)
+			continue
+		}
+		shared, ok := deltas[base.Tick]
+		if !ok {
+			d := diffSnapshots(base, snapshot)
+			shared = &d
+			deltas[base.Tick] = shared
+		}
+		delta := *shared
+		delta.LastInputSeq = p.lastInputSeq
+		p.Conn.SendRoomDelta(delta)
+	}
+}
+
+// applyInput applies in unless it is stale, and records its Seq as the
+// player's last processed input, also when the rules reject it: the
+// reply then carries the authoritative state the client reconciles with.
+func (room *GameRoom) applyInput(in *Input, now time.Time) InputResult {
+	player, exists := room.Players[in.PlayerID]
+	if !exists {
+		return InputResult{Err: ErrPlayerNotFound}
+	}
+
+	if in.Seq != 0 {
+		if in.Seq <= player.lastInputSeq {
+			return InputResult{Err: reject(player, RejectStale, "input %d was already processed", in.Seq)}
+		}
+		player.lastInputSeq = in.Seq
+	}
+
+	switch in.Kind {
+	case InputMove:
+		return room.applyMove(in, now)
+	case InputAttack:
+		return room.applyAttack(in, now)
+	default:
+		return InputResult{Err: fmt.Errorf("unknown input kind %d", in.Kind)}
+	}
+}
+
+func (room *GameRoom) snapshot(now time.Time) RoomSnapshot {
+	players := make([]PlayerState, 0, len(room.Players))
+	for _, p := range room.Players {
+		players = append(players, p.State())
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].ID < players[j].ID })
+
+	return RoomSnapshot{
+		RoomID:     room.ID,
+		Tick:       room.Tick,
+		ServerTime: now.UnixMilli(),
+		State:      room.State,
+		Players:    players,
+	}
+}
@@ -0,0 +1,133 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+type recordingConn struct {
+	snapshots chan RoomSnapshot
+	deltas    chan RoomDelta
+	events    chan interface{}
+}
+
+func (c *recordingConn) Send(v interface{}) error { return nil }
+
+func (c *recordingConn) SendEvent(eventType string, data interface{}) error {
+	select {
+	case c.events <- data:
+	default:
+	}
+	return nil
+}
+
+func (c *recordingConn) SendRoomState(snapshot RoomSnapshot) error {
+	select {
+	case c.snapshots <- snapshot:
+	default:
+	}
+	return nil
+}
+
+func (c *recordingConn) SendRoomDelta(delta RoomDelta) error {
+	select {
+	case c.deltas <- delta:
+	default:
+	}
+	return nil
+}
+
+func TestRoomLoopBroadcastsSnapshots(t *testing.T) {
+	m, roomID := newTestManager(t, nil), "r"
+	conn := &recordingConn{snapshots: make(chan RoomSnapshot, 64)}
+	p := &Player{ID: "p1", Name: "one", Health: 100, X: 10, Y: 10, Conn: conn}
+	m.AddPlayer(roomID, p)
+
+	next := func() RoomSnapshot {
+		t.Helper()
+		select {
+		case s := <-conn.snapshots:
+			return s
+		case <-time.After(time.Second):
+			t.Fatal("no snapshot within a second")
+			return RoomSnapshot{}
+		}
+	}
+
+	first := next()
+	if first.RoomID != roomID || len(first.Players) != 1 || first.Players[0].ID != "p1" {
+		t.Fatalf("snapshot = %+v", first)
+	}
+
+	// A move is applied by the loop and shows up in a later tick.
+	inRoom(t, m, roomID, func(*GameRoom) { p.lastMoveAt = time.Now().Add(-time.Second) })
+	if _, err := move(m, roomID, "p1", 0, 20, 10); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		s := next()
+		if s.Tick <= first.Tick {
+			t.Fatalf("tick went from %d to %d", first.Tick, s.Tick)
+		}
+		if s.Players[0].X == 20 {
+			break
+		}
+	}
+
+	// The loop stops with the last player.
+	m.RemovePlayer(roomID, p)
+	if _, err := move(m, roomID, "p1", 0, 20, 10); err != ErrPlayerNotFound {
+		t.Fatalf("move after leaving: got %v", err)
+	}
+}
+
+func TestInputsDoNotWaitForTheTick(t *testing.T) {
+	m, roomID := newTestManager(t, func(rules *Rules) { rules.TickRate = 1 }), "r"
+	p := &Player{ID: "p1", Health: 100, X: 10, Y: 10}
+	m.AddPlayer(roomID, p)
+	defer m.RemovePlayer(roomID, p)
+	inRoom(t, m, roomID, func(*GameRoom) { p.lastMoveAt = time.Now().Add(-time.Second) })
+
+	results := make(chan InputResult, 1)
+	start := time.Now()
+	if err := m.MovePlayer(roomID, "p1", 1, 20, 10, func(res InputResult) { results <- res }); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("MovePlayer waited %v for the tick", waited)
+	}
+
+	select {
+	case res := <-results:
+		if res.Err != nil || res.Player.X != 20 {
+			t.Errorf("result = %+v", res)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("input never applied")
+	}
+}
+
+func TestInputsAreAppliedBeforeLeaving(t *testing.T) {
+	m, roomID := newTestManager(t, func(rules *Rules) { rules.TickRate = 1 }), "r"
+	p := &Player{ID: "p1", Health: 100, X: 10, Y: 10}
+	m.AddPlayer(roomID, p)
+	m.AddPlayer(roomID, &Player{ID: "p2", Health: 100})
+	inRoom(t, m, roomID, func(*GameRoom) { p.lastMoveAt = time.Now().Add(-time.Second) })
+
+	results := make(chan InputResult, 1)
+	if err := m.MovePlayer(roomID, "p1", 1, 20, 10, func(res InputResult) { results <- res }); err != nil {
+		t.Fatal(err)
+	}
+	m.RemovePlayer(roomID, p)
+
+	// The move was applied before RemovePlayer returned, not failed on
+	// the next tick because the player had left.
+	select {
+	case res := <-results:
+		if res.Err != nil || res.Player.X != 20 {
+			t.Errorf("result = %+v", res)
+		}
+	default:
+		t.Fatal("a move sent before leaving was not applied")
+	}
+}
@@ -0,0 +1,400 @@
+package game
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MatchmakerConfig controls how queued players are grouped.
+type MatchmakerConfig struct {
+	// MatchSize is the number of players in a match. It should lie within
+	// the rooms' MinPlayers and MaxPlayers.
+	MatchSize int
+
+	// InitialBand is the rating difference accepted between a ticket and
+	// the oldest ticket of its match right away. The band grows by
+	// BandGrowth per second the oldest ticket has waited, up to MaxBand.
+	InitialBand float64
+	BandGrowth  float64
+	MaxBand     float64
+
+	// Interval is how often the queue is matched.
+	Interval time.Duration
+}
+
+var DefaultMatchmakerConfig = MatchmakerConfig{
+	MatchSize:   matchSizeFromEnv(),
+	InitialBand: 100,
+	BandGrowth:  25,
+	MaxBand:     800,
+	Interval:    time.Second,
+}
+
+// matchSizeFromEnv reads GAME_MATCH_SIZE.
+func matchSizeFromEnv() int {
+	size, err := strconv.Atoi(os.Getenv("GAME_MATCH_SIZE"))
+	if err != nil || size < DefaultRules.MinPlayers || size > DefaultRules.MaxPlayers {
+		return DefaultRules.MinPlayers
+	}
+	return size
+}
+
+// DefaultRating is the rating of players RatingLookup knows nothing about.
+const DefaultRating = 1500.0
+
+// RatingLookup returns the matchmaking ratings of players in a game mode,
+// see Rules.Mode. Players left out of the result get DefaultRating.
+type RatingLookup interface {
+	Ratings(mode string, playerIDs []string) (map[string]float64, error)
+}
+
+var (
+	ErrAlreadyQueued = errors.New("already in the matchmaking queue")
+	ErrNotQueued     = errors.New("not in the matchmaking queue")
+	ErrEmptyParty    = errors.New("a party needs at least one player")
+	ErrPartyTooLarge = errors.New("party does not fit in a match")
+	ErrNoRegion      = errors.New("at least one region is required")
+)
+
+// Ticket is a party waiting in the queue. A party is matched as a whole,
+// at the average rating of its members.
+type Ticket struct {
+	ID        string    `json:"ticketId"`
+	PlayerIDs []string  `json:"playerIds"`
+	Regions   []string  `json:"regions"`
+	Rating    float64   `json:"rating"`
+	QueuedAt  time.Time `json:"queuedAt"`
+}
+
+// Match is a group of tickets given a room of their own.
+type Match struct {
+	RoomID    string   `json:"roomId"`
+	Region    string   `json:"region"`
+	PlayerIDs []string `json:"playerIds"`
+}
+
+var (
+	matchmakingMetrics = expvar.NewMap("game_matchmaking")
+
+	queuedPlayers = new(expvar.Int)
+	matchesMade   = new(expvar.Int)
+)
+
+func init() {
+	matchmakingMetrics.Set("queued", queuedPlayers)
+	matchmakingMetrics.Set("matches", matchesMade)
+}
+
+// Matchmaker groups queued parties into matches of MatchSize players that
+// share a region and whose ratings lie within a band of the longest
+// waiting party, then reserves a room for each match and reports it to
+// onMatch.
+type Matchmaker struct {
+	cfg     MatchmakerConfig
+	rooms   *RoomManager
+	ratings RatingLookup // nil rates everyone DefaultRating
+	onMatch func(Match)
+
+	mu       sync.Mutex
+	tickets  []*Ticket // oldest first
+	byPlayer map[string]*Ticket
+	stop     chan struct{} // nil until Start
+	done     chan struct{}
+}
+
+func NewMatchmaker(cfg MatchmakerConfig, rooms *RoomManager, ratings RatingLookup, onMatch func(Match)) *Matchmaker {
+	return &Matchmaker{
+		cfg:      cfg,
+		rooms:    rooms,
+		ratings:  ratings,
+		onMatch:  onMatch,
+		byPlayer: make(map[string]*Ticket),
+	}
+}
+
+// Start matches the queue every Interval until Close.
+func (mm *Matchmaker) Start() {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if mm.stop != nil {
+		return
+	}
+	mm.stop = make(chan struct{})
+	mm.done = make(chan struct{})
+	go mm.run(mm.stop, mm.done)
+}
+
+// Close stops matching. Tickets still queued are dropped.
+func (mm *Matchmaker) Close() {
+	mm.mu.Lock()
+	stop, done := mm.stop, mm.done
+	mm.stop = nil
+	for _, t := range mm.tickets {
+		queuedPlayers.Add(-int64(len(t.PlayerIDs)))
+	}
+	mm.tickets = nil
+	mm.byPlayer = make(map[string]*Ticket)
+	mm.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (mm *Matchmaker) run(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(mm.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			mm.tick(now)
+		}
+	}
+}
+
+// Join queues playerIDs as one party looking for a match in any of
+// regions, listed by preference.
+func (mm *Matchmaker) Join(playerIDs, regions []string) (Ticket, error) {
+	party := dedupe(playerIDs)
+	regions = dedupe(regions)
+	switch {
+	case len(party) == 0:
+		return Ticket{}, ErrEmptyParty
+	case len(party) > mm.cfg.MatchSize:
+		return Ticket{}, ErrPartyTooLarge
+	case len(regions) == 0:
+		return Ticket{}, ErrNoRegion
+	}
+
+	rating, err := mm.partyRating(party)
+	if err != nil {
+		return Ticket{}, err
+	}
+	id, err := randomID()
+	if err != nil {
+		return Ticket{}, err
+	}
+	ticket := &Ticket{ID: id, PlayerIDs: party, Regions: regions, Rating: rating, QueuedAt: time.Now()}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for _, id := range party {
+		if _, queued := mm.byPlayer[id]; queued {
+			return Ticket{}, ErrAlreadyQueued
+		}
+	}
+	mm.enqueueLocked(ticket)
+	return *ticket, nil
+}
+
+// Leave takes the party of playerID out of the queue.
+func (mm *Matchmaker) Leave(playerID string) (Ticket, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	ticket, queued := mm.byPlayer[playerID]
+	if !queued {
+		return Ticket{}, ErrNotQueued
+	}
+	mm.removeLocked(map[*Ticket]bool{ticket: true})
+	return *ticket, nil
+}
+
+func (mm *Matchmaker) partyRating(party []string) (float64, error) {
+	if mm.ratings == nil {
+		return DefaultRating, nil
+	}
+	ratings, err := mm.ratings.Ratings(mm.rooms.Rules().Mode, party)
+	if err != nil {
+		return 0, err
+	}
+
+	sum := 0.0
+	for _, id := range party {
+		if r, ok := ratings[id]; ok {
+			sum += r
+		} else {
+			sum += DefaultRating
+		}
+	}
+	return sum / float64(len(party)), nil
+}
+
+// enqueueLocked adds t to the queue, keeping it ordered by QueuedAt.
+func (mm *Matchmaker) enqueueLocked(t *Ticket) {
+	i := len(mm.tickets)
+	for i > 0 && mm.tickets[i-1].QueuedAt.After(t.QueuedAt) {
+		i--
+	}
+	mm.tickets = append(mm.tickets, nil)
+	copy(mm.tickets[i+1:], mm.tickets[i:])
+	mm.tickets[i] = t
+
+	for _, id := range t.PlayerIDs {
+		mm.byPlayer[id] = t
+	}
+	queuedPlayers.Add(int64(len(t.PlayerIDs)))
+}
+
+func (mm *Matchmaker) removeLocked(remove map[*Ticket]bool) {
+	kept := mm.tickets[:0]
+	for _, t := range mm.tickets {
+		if !remove[t] {
+			kept = append(kept, t)
+			continue
+		}
+		for _, id := range t.PlayerIDs {
+			delete(mm.byPlayer, id)
+		}
+		queuedPlayers.Add(-int64(len(t.PlayerIDs)))
+	}
+	mm.tickets = kept
+}
+
+// band is the rating difference accepted for a ticket that has waited
+// for wait.
+func (mm *Matchmaker) band(wait time.Duration) float64 {
+	return math.Min(mm.cfg.InitialBand+mm.cfg.BandGrowth*wait.Seconds(), mm.cfg.MaxBand)
+}
+
+type pendingMatch struct {
+	region  string
+	tickets []*Ticket
+}
+
+// tick takes every match the queue allows out of it, then reserves their
+// rooms and reports them. Tickets whose room cannot be created go back in
+// the queue.
+func (mm *Matchmaker) tick(now time.Time) {
+	mm.mu.Lock()
+	matches := mm.matchLocked(now)
+	mm.mu.Unlock()
+
+	for _, pm := range matches {
+		match := Match{Region: pm.region}
+		for _, t := range pm.tickets {
+			match.PlayerIDs = append(match.PlayerIDs, t.PlayerIDs...)
+		}
+
+		id, err := randomID()
+		if err == nil {
+			match.RoomID = "match-" + id
+			err = mm.rooms.CreateRoom(match.RoomID, match.PlayerIDs)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrClosed) {
+				mm.requeue(pm.tickets)
+			}
+			continue
+		}
+
+		matchesMade.Add(1)
+		if mm.onMatch != nil {
+			mm.onMatch(match)
+		}
+	}
+}
+
+// requeue puts tickets back in their place in the queue, unless one of
+// their players queued again meanwhile.
+func (mm *Matchmaker) requeue(tickets []*Ticket) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for _, t := range tickets {
+		queued := false
+		for _, id := range t.PlayerIDs {
+			_, inQueue := mm.byPlayer[id]
+			queued = queued || inQueue
+		}
+		if !queued {
+			mm.enqueueLocked(t)
+		}
+	}
+}
+
+// matchLocked goes through the queue oldest first. Each ticket not yet
+// matched anchors a match: in each of its regions in turn, the oldest
+// tickets that share the region, fit in the match and lie within the
+// anchor's band are added until the match is full.
+func (mm *Matchmaker) matchLocked(now time.Time) []pendingMatch {
+	var matches []pendingMatch
+	matched := make(map[*Ticket]bool)
+
+	for i, anchor := range mm.tickets {
+		if matched[anchor] {
+			continue
+		}
+		band := mm.band(now.Sub(anchor.QueuedAt))
+
+		for _, region := range anchor.Regions {
+			group := []*Ticket{anchor}
+			size := len(anchor.PlayerIDs)
+			for _, t := range mm.tickets[i+1:] {
+				if size == mm.cfg.MatchSize {
+					break
+				}
+				if matched[t] || size+len(t.PlayerIDs) > mm.cfg.MatchSize ||
+					math.Abs(t.Rating-anchor.Rating) > band || !contains(t.Regions, region) {
+					continue
+				}
+				group = append(group, t)
+				size += len(t.PlayerIDs)
+			}
+
+			if size == mm.cfg.MatchSize {
+				for _, t := range group {
+					matched[t] = true
+				}
+				matches = append(matches, pendingMatch{region: region, tickets: group})
+				break
+			}
+		}
+	}
+
+	mm.removeLocked(matched)
+	return matches
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,136 @@
+package game
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+type fixedRatings map[string]float64
+
+func (r fixedRatings) Ratings(mode string, playerIDs []string) (map[string]float64, error) {
+	return r, nil
+}
+
+func newTestMatchmaker(t *testing.T, size int, ratings RatingLookup) (*Matchmaker, *RoomManager, *[]Match) {
+	m := newTestManager(t, nil)
+	var matches []Match
+	cfg := MatchmakerConfig{MatchSize: size, InitialBand: 100, BandGrowth: 25, MaxBand: 800, Interval: time.Hour}
+	mm := NewMatchmaker(cfg, m, ratings, func(match Match) { matches = append(matches, match) })
+	t.Cleanup(mm.Close)
+	return mm, m, &matches
+}
+
+func join(t *testing.T, mm *Matchmaker, regions []string, players ...string) {
+	t.Helper()
+	if _, err := mm.Join(players, regions); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func matchedPlayers(match Match) []string {
+	players := append([]string(nil), match.PlayerIDs...)
+	sort.Strings(players)
+	return players
+}
+
+func TestMatchmakerWidensRatingBand(t *testing.T) {
+	mm, _, matches := newTestMatchmaker(t, 2, fixedRatings{"a": 1500, "b": 1550, "c": 2000, "d": 2300})
+	eu := []string{"eu"}
+	join(t, mm, eu, "a")
+	join(t, mm, eu, "c")
+	join(t, mm, eu, "b")
+	join(t, mm, eu, "d")
+
+	now := time.Now()
+	mm.tick(now)
+	if len(*matches) != 1 || matchedPlayers((*matches)[0])[0] != "a" || matchedPlayers((*matches)[0])[1] != "b" {
+		t.Fatalf("first matches = %+v, want a with b", *matches)
+	}
+
+	// c and d are 300 apart: the band reaches that after 8 seconds.
+	mm.tick(now.Add(5 * time.Second))
+	if len(*matches) != 1 {
+		t.Fatalf("c and d matched with a band of 225: %+v", *matches)
+	}
+	mm.tick(now.Add(9 * time.Second))
+	if len(*matches) != 2 {
+		t.Fatalf("c and d not matched once the band widened: %+v", *matches)
+	}
+}
+
+func TestMatchmakerKeepsPartiesTogether(t *testing.T) {
+	mm, _, matches := newTestMatchmaker(t, 4, nil)
+	eu := []string{"eu"}
+	join(t, mm, eu, "s1")
+	join(t, mm, eu, "p1", "p2", "p3")
+	join(t, mm, eu, "s2")
+	join(t, mm, eu, "s3")
+
+	if _, err := mm.Join([]string{"x1", "x2", "x3", "x4", "x5"}, eu); !errors.Is(err, ErrPartyTooLarge) {
+		t.Errorf("party of five: got %v", err)
+	}
+	if _, err := mm.Join([]string{"x1", "p2"}, eu); !errors.Is(err, ErrAlreadyQueued) {
+		t.Errorf("queued twice: got %v", err)
+	}
+
+	// s1 anchors; the party of three still fits, s2 then no longer does.
+	mm.tick(time.Now())
+	if len(*matches) != 1 {
+		t.Fatalf("matches = %+v", *matches)
+	}
+	got := matchedPlayers((*matches)[0])
+	if len(got) != 4 || got[0] != "p1" || got[3] != "s1" {
+		t.Errorf("match = %v, want the party with s1", got)
+	}
+
+	if _, err := mm.Leave("s3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mm.Leave("s3"); !errors.Is(err, ErrNotQueued) {
+		t.Errorf("left twice: got %v", err)
+	}
+}
+
+func TestMatchmakerRespectsRegions(t *testing.T) {
+	mm, _, matches := newTestMatchmaker(t, 2, nil)
+	join(t, mm, []string{"eu"}, "x")
+	join(t, mm, []string{"us"}, "y")
+	join(t, mm, []string{"us", "eu"}, "z")
+
+	mm.tick(time.Now())
+	if len(*matches) != 1 {
+		t.Fatalf("matches = %+v", *matches)
+	}
+	if match := (*matches)[0]; match.Region != "eu" || matchedPlayers(match)[0] != "x" || matchedPlayers(match)[1] != "z" {
+		t.Errorf("match = %+v, want x with z in eu", match)
+	}
+	if _, err := mm.Leave("y"); err != nil {
+		t.Errorf("y is no longer queued: %v", err)
+	}
+}
+
+func TestMatchReservesRoom(t *testing.T) {
+	mm, m, matches := newTestMatchmaker(t, 2, nil)
+	join(t, mm, []string{"eu"}, "a")
+	join(t, mm, []string{"eu"}, "b")
+	mm.tick(time.Now())
+	if len(*matches) != 1 {
+		t.Fatalf("matches = %+v", *matches)
+	}
+	roomID := (*matches)[0].RoomID
+
+	if err := m.AddPlayer(roomID, &Player{ID: "stranger"}); !errors.Is(err, ErrNotReserved) {
+		t.Errorf("stranger joined the match room: %v", err)
+	}
+	a := &Player{ID: "a", Health: 100}
+	if err := m.AddPlayer(roomID, a); err != nil {
+		t.Fatalf("matched player: %v", err)
+	}
+	defer m.RemovePlayer(roomID, a)
+
+	if err := m.CreateRoom(roomID, nil); !errors.Is(err, ErrRoomExists) {
+		t.Errorf("room created twice: %v", err)
+	}
+}
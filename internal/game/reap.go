@@ -0,0 +1,64 @@
+package game
+
+import (
+	"expvar"
+	"time"
+)
+
+var (
+	roomMetrics = expvar.NewMap("game_rooms")
+
+	roomsActive        = new(expvar.Int)
+	playersInRooms     = new(expvar.Int)
+	roomsReaped        = new(expvar.Int)
+	playersLeft        = new(expvar.Int)
+	playersDisconnects = new(expvar.Int)
+)
+
+func init() {
+	roomMetrics.Set("active", roomsActive)
+	roomMetrics.Set("players", playersInRooms)
+	roomMetrics.Set("reaped", roomsReaped)
+	roomMetrics.Set("players_left", playersLeft)
+	roomMetrics.Set("players_disconnected", playersDisconnects)
+}
+
+// scheduleReap reaps the room once it has been empty for its ReapGrace,
+// which gives disconnected players time to come back to it.
+func (room *GameRoom) scheduleReap(now time.Time) {
+	room.emptySince = now
+	if room.reapTimer != nil {
+		room.reapTimer.Stop()
+	}
+	room.reapTimer = time.AfterFunc(room.Rules.ReapGrace, func() {
+		room.call(room.reap)
+	})
+}
+
+// cancelReap keeps the room now that a player joined it.
+func (room *GameRoom) cancelReap() {
+	room.emptySince = time.Time{}
+	if room.reapTimer != nil {
+		room.reapTimer.Stop()
+		room.reapTimer = nil
+	}
+}
+
+// reap removes the room from its manager if it is still empty and its
+// grace is over, which ends the room goroutine. Its match, finished or
+// not, is dropped with it.
+func (room *GameRoom) reap() {
+	if room.reaped || len(room.Players) > 0 || room.emptySince.IsZero() {
+		return
+	}
+	if time.Since(room.emptySince) < room.Rules.ReapGrace {
+		// A timer from an earlier time the room was empty.
+		return
+	}
+
+	room.reaped = true
+	room.reapTimer = nil
+	room.manager.forget(room)
+	roomsActive.Add(-1)
+	roomsReaped.Add(1)
+}
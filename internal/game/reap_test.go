@@ -0,0 +1,82 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaveAndReap(t *testing.T) {
+	m, roomID := newTestManager(t, func(rules *Rules) { rules.ReapGrace = 50 * time.Millisecond }), "r"
+
+	conn := &recordingConn{events: make(chan interface{}, 64)}
+	a := &Player{ID: "a", Health: 100, Conn: conn}
+	b := &Player{ID: "b", Health: 100}
+	m.AddPlayer(roomID, a)
+	m.AddPlayer(roomID, b)
+	room, _ := m.find(roomID)
+
+	reaped := roomsReaped.Value()
+	if !m.DisconnectPlayer(roomID, b) {
+		t.Fatal("b was not removed")
+	}
+	for left := false; !left; {
+		select {
+		case ev := <-conn.events:
+			if l, ok := ev.(PlayerLeft); ok {
+				if l.PlayerID != "b" || l.Reason != LeaveDisconnected {
+					t.Fatalf("leave event = %+v", l)
+				}
+				left = true
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no leave event")
+		}
+	}
+	if m.DisconnectPlayer(roomID, b) {
+		t.Error("b was removed twice")
+	}
+
+	m.RemovePlayer(roomID, a)
+	if _, exists := m.find(roomID); !exists {
+		t.Fatal("empty room reaped before its grace")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, exists := m.find(roomID); !exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("empty room never reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := roomsReaped.Value(); got != reaped+1 {
+		t.Errorf("reaped metric = %d, want %d", got, reaped+1)
+	}
+
+	// Joining the same ID afterwards starts a fresh room.
+	if err := m.AddPlayer(roomID, a); err != nil {
+		t.Fatal(err)
+	}
+	defer m.RemovePlayer(roomID, a)
+	if fresh, _ := m.find(roomID); fresh == room {
+		t.Error("rejoined the reaped room")
+	}
+}
+
+func TestRejoinCancelsReap(t *testing.T) {
+	m, roomID := newTestManager(t, func(rules *Rules) { rules.ReapGrace = 50 * time.Millisecond }), "r"
+
+	p := &Player{ID: "p", Health: 100}
+	m.AddPlayer(roomID, p)
+	room, _ := m.find(roomID)
+	m.RemovePlayer(roomID, p)
+	m.AddPlayer(roomID, p)
+	defer m.RemovePlayer(roomID, p)
+
+	time.Sleep(150 * time.Millisecond)
+	if got, exists := m.find(roomID); !exists || got != room {
+		t.Error("occupied room was reaped")
+	}
+}
@@ -0,0 +1,172 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Rules are the limits the server enforces on player actions. Clients only
+// request actions; positions and health are decided here.
+type Rules struct {
+	// Mode names the rule set, e.g. for per-mode ratings.
+	Mode string
+
+	Width, Height float64 // the map spans [0, Width] x [0, Height]
+
+	MaxSpeed float64 // units per second
+	// MaxMoveWindow caps the time credited to a single move, so a player
+	// who stood still cannot cover the whole map in one step.
+	MaxMoveWindow time.Duration
+	// SpeedTolerance allows for network jitter, as a fraction of MaxSpeed.
+	SpeedTolerance float64
+
+	AttackRange    float64
+	AttackDamage   int
+	AttackCooldown time.Duration
+
+	MaxHealth int
+
+	// RespawnDelay is how long dead players stay out before coming back
+	// at one of the SpawnPoints. 0 disables respawning: the dead stay out
+	// until the match ends, which then ends with the last one standing.
+	RespawnDelay time.Duration
+	SpawnPoints  []SpawnPoint
+
+	// MinPlayers must be ready for the countdown to start; a match ends
+	// when fewer remain. MaxPlayers caps joins.
+	MinPlayers int
+	MaxPlayers int
+
+	CountdownDuration time.Duration
+	// MatchDuration is the match time limit; 0 means none.
+	MatchDuration     time.Duration
+	PostMatchDuration time.Duration
+
+	// TickRate is how many times per second the room loop runs.
+	TickRate int
+
+	// MaxRewind bounds how far back in time an attack may rewind target
+	// positions to match what the attacker saw.
+	MaxRewind time.Duration
+
+	// ReapGrace is how long an empty room is kept before it is deleted.
+	ReapGrace time.Duration
+
+	// SnapshotHistory is how many past ticks are kept as delta baselines.
+	// Players whose last acknowledged tick is older get a full snapshot.
+	SnapshotHistory int
+}
+
+var DefaultRules = Rules{
+	Mode: modeFromEnv(),
+
+	Width:          1000,
+	Height:         1000,
+	MaxSpeed:       200,
+	MaxMoveWindow:  time.Second,
+	SpeedTolerance: 0.1,
+	AttackRange:    50,
+	AttackDamage:   10,
+	AttackCooldown: 500 * time.Millisecond,
+	MaxHealth:      100,
+
+	RespawnDelay: respawnDelayFromEnv(),
+	SpawnPoints: []SpawnPoint{
+		{X: 100, Y: 100}, {X: 900, Y: 100}, {X: 100, Y: 900}, {X: 900, Y: 900},
+		{X: 500, Y: 100}, {X: 500, Y: 900}, {X: 100, Y: 500}, {X: 900, Y: 500},
+	},
+
+	MinPlayers:        2,
+	MaxPlayers:        8,
+	CountdownDuration: 5 * time.Second,
+	MatchDuration:     5 * time.Minute,
+	PostMatchDuration: 10 * time.Second,
+
+	TickRate:        tickRateFromEnv(),
+	MaxRewind:       maxRewindFromEnv(),
+	SnapshotHistory: 64,
+	ReapGrace:       reapGraceFromEnv(),
+}
+
+// modeFromEnv reads GAME_MODE.
+func modeFromEnv() string {
+	if mode := os.Getenv("GAME_MODE"); mode != "" {
+		return mode
+	}
+	return "deathmatch"
+}
+
+// tickRateFromEnv reads GAME_TICK_RATE, in ticks per second.
+func tickRateFromEnv() int {
+	rate, err := strconv.Atoi(os.Getenv("GAME_TICK_RATE"))
+	if err != nil || rate < 1 || rate > 128 {
+		return 20
+	}
+	return rate
+}
+
+// maxRewindFromEnv reads GAME_MAX_REWIND, e.g. "250ms".
+func maxRewindFromEnv() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("GAME_MAX_REWIND"))
+	if err != nil || d < 0 || d > time.Second {
+		return 200 * time.Millisecond
+	}
+	return d
+}
+
+// respawnDelayFromEnv reads GAME_RESPAWN_DELAY, e.g. "3s"; "0" disables
+// respawning.
+func respawnDelayFromEnv() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("GAME_RESPAWN_DELAY"))
+	if err != nil || d < 0 {
+		return 3 * time.Second
+	}
+	return d
+}
+
+// reapGraceFromEnv reads GAME_ROOM_REAP_GRACE, e.g. "30s".
+func reapGraceFromEnv() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("GAME_ROOM_REAP_GRACE"))
+	if err != nil || d < 0 {
+		return 30 * time.Second
+	}
+	return d
+}
+
+var (
+	ErrRoomNotFound   = errors.New("room not found")
+	ErrPlayerNotFound = errors.New("player is not in the room")
+	ErrTargetNotFound = errors.New("target is not in the room")
+)
+
+// Reasons an action is rejected.
+const (
+	RejectTooFast     = "too_fast"
+	RejectOutOfBounds = "out_of_bounds"
+	RejectOutOfRange  = "out_of_range"
+	RejectCooldown    = "cooldown"
+	RejectDead        = "dead"
+	RejectSelfTarget  = "self_target"
+	RejectStale       = "stale_input"
+	RejectNotActive   = "match_not_active"
+)
+
+// ActionError rejects a move or attack. Player holds the authoritative
+// state of the acting player so the client can correct its prediction.
+type ActionError struct {
+	Reason     string
+	Message    string
+	RetryAfter time.Duration // set for RejectCooldown, and RejectDead until the respawn
+	Player     PlayerState
+}
+
+func (e *ActionError) Error() string {
+	return e.Message
+}
+
+func reject(p *Player, reason, format string, args ...interface{}) *ActionError {
+	return &ActionError{Reason: reason, Message: fmt.Sprintf(format, args...), Player: p.State()}
+}
@@ -1,71 +1,459 @@
 package game
 
-import "sync"
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
 
 var (
-	gameRooms = make(map[string]*GameRoom)
-	roomsMu   sync.Mutex
+	// ErrClosed is returned for rooms that would be created after Close.
+	ErrClosed      = errors.New("room manager is closed")
+	ErrRoomExists  = errors.New("room already exists")
+	ErrNotReserved = errors.New("room is reserved for other players")
 )
-func GetOrCreateRoom(roomID string) *GameRoom {
-	roomsMu.Lock()
-	defer roomsMu.Unlock()
 
-	room, exists := gameRooms[roomID]
+// RoomManager owns a set of game rooms. Each room runs on a goroutine of
+// its own, see GameRoom.run; the manager only maps room IDs to rooms, and
+// its methods hand their work to the room's goroutine.
+type RoomManager struct {
+	rules Rules
+
+	mu         sync.Mutex
+	rooms      map[string]*GameRoom
+	closed     bool
+	onMatchEnd func(RoomResult)
+	callbacks  sync.WaitGroup // onMatchEnd calls still running
+}
+
+// NewRoomManager returns a manager whose rooms play by rules.
+func NewRoomManager(rules Rules) *RoomManager {
+	return &RoomManager{rules: rules, rooms: make(map[string]*GameRoom)}
+}
+
+// Rules returns the rules new rooms are created with.
+func (m *RoomManager) Rules() Rules {
+	return m.rules
+}
+
+// OnMatchEnd sets fn to receive the result of every match that ends. It
+// runs on a goroutine of its own, so it may block; Close waits for it.
+func (m *RoomManager) OnMatchEnd(fn func(RoomResult)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onMatchEnd = fn
+}
+
+func (m *RoomManager) matchEnded(result RoomResult) {
+	m.mu.Lock()
+	fn := m.onMatchEnd
+	m.mu.Unlock()
+
+	if fn != nil {
+		m.callbacks.Add(1)
+		go func() {
+			defer m.callbacks.Done()
+			fn(result)
+		}()
+	}
+}
+
+// getOrCreate returns roomID, creating and starting it if needed. Rooms
+// are reaped once they have been empty for their Rules.ReapGrace.
+func (m *RoomManager) getOrCreate(roomID string) (*GameRoom, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+	room, exists := m.rooms[roomID]
 	if !exists {
-		room = &GameRoom{
-			ID:      roomID,
-			Players: make(map[string]*Player),
-			State:   "waiting",
-		}
-		gameRooms[roomID] = room
+		room = newGameRoom(m, roomID, m.rules)
+		m.rooms[roomID] = room
+		roomsActive.Add(1)
+		go room.run()
+	}
+	return room, nil
+}
+
+// CreateRoom creates roomID with its seats reserved for playerIDs, as for
+// a match made by a Matchmaker. Other players cannot join it. The room is
+// reaped like any other if nobody joins it within its ReapGrace.
+func (m *RoomManager) CreateRoom(roomID string, playerIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	if _, exists := m.rooms[roomID]; exists {
+		return ErrRoomExists
+	}
+
+	room := newGameRoom(m, roomID, m.rules)
+	room.reserved = make(map[string]bool, len(playerIDs))
+	for _, id := range playerIDs {
+		room.reserved[id] = true
+	}
+	m.rooms[roomID] = room
+	roomsActive.Add(1)
+	go room.run()
+	return nil
+}
+
+// find returns an existing room without creating it.
+func (m *RoomManager) find(roomID string) (*GameRoom, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, exists := m.rooms[roomID]
+	return room, exists
+}
+
+// forget drops room from the manager. It runs on the room goroutine as
+// the room is reaped.
+func (m *RoomManager) forget(room *GameRoom) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rooms[room.ID] == room {
+		delete(m.rooms, room.ID)
 	}
-	return room
 }
 
+// do runs fn on the goroutine of roomID and waits for it. It returns
+// ErrRoomNotFound when there is no such room; rooms are only created by
+// AddPlayer.
+func (m *RoomManager) do(roomID string, fn func(room *GameRoom)) error {
+	room, exists := m.find(roomID)
+	if !exists {
+		return ErrRoomNotFound
+	}
+	return room.call(func() { fn(room) })
+}
+
+// snapshotRooms returns the current rooms. The manager lock must not be
+// held while calling into a room, as rooms take it when they are reaped.
+func (m *RoomManager) snapshotRooms() []*GameRoom {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rooms := make([]*GameRoom, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
 
+// Close stops every room, ending the matches still running with
+// ReasonServerShutdown, and returns once OnMatchEnd has handled every
+// match that ended. Later calls fail with ErrRoomNotFound, and AddPlayer
+// with ErrClosed.
+func (m *RoomManager) Close() {
+	m.mu.Lock()
+	rooms := make([]*GameRoom, 0, len(m.rooms))
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	m.rooms = make(map[string]*GameRoom)
+	m.closed = true
+	m.mu.Unlock()
 
+	for _, room := range rooms {
+		close(room.quit)
+		<-room.done
+	}
+	m.callbacks.Wait()
+}
 
+// AddPlayer seats p in roomID, creating the room if needed. New players
+// may only join a room in its lobby with a free seat; a player who is
+// already in the room takes over their seat, keeping its state.
+func (m *RoomManager) AddPlayer(roomID string, p *Player) error {
+	for {
+		room, err := m.getOrCreate(roomID)
+		if err != nil {
+			return err
+		}
+		var joinErr error
+		if err := room.call(func() { joinErr = room.addPlayer(p, time.Now()) }); err == nil {
+			return joinErr
+		}
+		// Reaped since we got it; the next lookup creates a new one.
+	}
+}
 
-func AddPlayerToRoom(roomID string, p *Player) {
-	room := GetOrCreateRoom(roomID)
-	room.Mu.Lock()
-	defer room.Mu.Unlock()
+func (room *GameRoom) addPlayer(p *Player, now time.Time) error {
+	if existing, ok := room.Players[p.ID]; ok {
+		p.X, p.Y, p.Health, p.ready = existing.X, existing.Y, existing.Health, existing.ready
+		p.lastAttackAt, p.lastInputSeq, p.history = existing.lastAttackAt, existing.lastInputSeq, existing.history
+		p.kills, p.deaths, p.respawnAt = existing.kills, existing.deaths, existing.respawnAt
+		p.damageDealt, p.damageTaken = existing.damageDealt, existing.damageTaken
+	} else {
+		if room.reserved != nil && !room.reserved[p.ID] {
+			return ErrNotReserved
+		}
+		if room.State != RoomWaiting && room.State != RoomCountdown {
+			return ErrMatchInProgress
+		}
+		if len(room.Players) >= room.Rules.MaxPlayers {
+			return ErrRoomFull
+		}
+		playersInRooms.Add(1)
+	}
 
+	p.lastMoveAt = now
 	room.Players[p.ID] = p
+	room.cancelReap()
+	room.startTicking()
+	room.evaluate(now)
+	return nil
 }
 
+// ReattachPlayer points p's seat in roomID at a new connection after a
+// session resumption. It reports false when the seat is gone.
+func (m *RoomManager) ReattachPlayer(roomID string, p *Player, conn Conn) bool {
+	attached := false
+	m.do(roomID, func(room *GameRoom) {
+		if room.Players[p.ID] == p {
+			p.Conn = conn
+			attached = true
+		}
+	})
+	return attached
+}
 
+// Reasons reported in PlayerLeft.
+const (
+	LeaveRequested    = "left"
+	LeaveDisconnected = "disconnected"
+)
+
+// PlayerLeft is broadcast to the remaining room members when a player
+// leaves or is dropped.
+type PlayerLeft struct {
+	RoomID   string `json:"roomId"`
+	PlayerID string `json:"playerId"`
+	Reason   string `json:"reason"`
+}
+
+// RemovePlayer takes p out of roomID at their request. It is a no-op when
+// the seat has since been taken over by another connection of the same
+// player.
+func (m *RoomManager) RemovePlayer(roomID string, p *Player) bool {
+	return m.removePlayer(roomID, p, LeaveRequested)
+}
+
+// DisconnectPlayer takes p out of roomID once their connection is gone for
+// good, under the same rules as RemovePlayer.
+func (m *RoomManager) DisconnectPlayer(roomID string, p *Player) bool {
+	return m.removePlayer(roomID, p, LeaveDisconnected)
+}
 
+func (m *RoomManager) removePlayer(roomID string, p *Player, reason string) bool {
+	removed := false
+	m.do(roomID, func(room *GameRoom) {
+		if room.Players[p.ID] != p {
+			return
+		}
+		room.applyInputsOf(p.ID, time.Now())
+		delete(room.Players, p.ID)
+		removed = true
+		if room.State == RoomActive {
+			departed := p.result()
+			departed.Left = true
+			room.departed = append(room.departed, departed)
+		}
+		playersInRooms.Add(-1)
+		if reason == LeaveDisconnected {
+			playersDisconnects.Add(1)
+		} else {
+			playersLeft.Add(1)
+		}
+
+		now := time.Now()
+		room.emit(EventPlayerLeft, PlayerLeft{RoomID: room.ID, PlayerID: p.ID, Reason: reason})
+		room.evaluate(now)
+		if len(room.Players) == 0 {
+			room.stopTicking()
+			room.scheduleReap(now)
+		}
+	})
+	return removed
+}
 
-func MovePlayer(roomID, playerID string, x, y float64) bool {
-	room := GetOrCreateRoom(roomID)
-	room.Mu.Lock()
-	defer room.Mu.Unlock()
+// MovePlayer asks to move playerID to (x, y) as input seq, see Input.Seq.
+// The move is queued and applied by the room loop on its next tick, or
+// before a later RemovePlayer or SetReady of the player, if the room's
+// rules allow it: the destination must be on the map and reachable
+// at MaxSpeed since the player's last move. done then receives the
+// player's authoritative state, see submitInput. The error is for inputs
+// that could not be queued.
+func (m *RoomManager) MovePlayer(roomID, playerID string, seq uint64, x, y float64, done func(InputResult)) error {
+	return m.submitInput(roomID, &Input{PlayerID: playerID, Kind: InputMove, Seq: seq, X: x, Y: y}, done)
+}
 
-	player, exists := room.Players[playerID]
+func (room *GameRoom) applyMove(in *Input, now time.Time) InputResult {
+	player, exists := room.Players[in.PlayerID]
 	if !exists {
-		return false
+		return InputResult{Err: ErrPlayerNotFound}
 	}
-	player.X = x
-	player.Y = y
-	return true
-}
+	rules := room.Rules
 
+	if player.Health <= 0 {
+		return InputResult{Err: rejectDead(player, now, "dead players cannot move")}
+	}
+	if in.X < 0 || in.Y < 0 || in.X > rules.Width || in.Y > rules.Height {
+		return InputResult{Err: reject(player, RejectOutOfBounds, "(%g, %g) is outside the %gx%g map", in.X, in.Y, rules.Width, rules.Height)}
+	}
 
+	elapsed := now.Sub(player.lastMoveAt)
+	if elapsed > rules.MaxMoveWindow {
+		elapsed = rules.MaxMoveWindow
+	}
+	allowed := rules.MaxSpeed * elapsed.Seconds() * (1 + rules.SpeedTolerance)
+	if dist := math.Hypot(in.X-player.X, in.Y-player.Y); dist > allowed {
+		return InputResult{Err: reject(player, RejectTooFast, "moved %.1f units, at most %.1f allowed", dist, allowed)}
+	}
 
-func ApplyDamage(roomID, targetID string, amount int) bool {
-	room := GetOrCreateRoom(roomID)
-	room.Mu.Lock()
-	defer room.Mu.Unlock()
+	player.X = in.X
+	player.Y = in.Y
+	player.lastMoveAt = now
+	return InputResult{Player: player.State()}
+}
 
-	target, exists := room.Players[targetID]
+// Attack asks for attackerID to hit targetID for the room's AttackDamage,
+// as input seq. It is applied on the room's next tick if the target is
+// alive and the attacker off cooldown. Range is checked against where the
+// target was at viewTime, see Rules.MaxRewind. done then receives the
+// authoritative state of both players, as in MovePlayer.
+func (m *RoomManager) Attack(roomID, attackerID string, seq uint64, targetID string, viewTime time.Time, done func(InputResult)) error {
+	return m.submitInput(roomID, &Input{PlayerID: attackerID, Kind: InputAttack, Seq: seq, TargetID: targetID, ViewTime: viewTime}, done)
+}
+
+func (room *GameRoom) applyAttack(in *Input, now time.Time) InputResult {
+	a, exists := room.Players[in.PlayerID]
+	if !exists {
+		return InputResult{Err: ErrPlayerNotFound}
+	}
+	t, exists := room.Players[in.TargetID]
 	if !exists {
-		return false
+		return InputResult{Err: ErrTargetNotFound}
+	}
+	rules := room.Rules
+
+	switch {
+	case room.State != RoomActive:
+		return InputResult{Err: reject(a, RejectNotActive, "the match is not running")}
+	case a == t:
+		return InputResult{Err: reject(a, RejectSelfTarget, "players cannot attack themselves")}
+	case a.Health <= 0:
+		return InputResult{Err: rejectDead(a, now, "dead players cannot attack")}
+	case t.Health <= 0:
+		return InputResult{Err: reject(a, RejectDead, "target is already dead")}
 	}
-	target.Health -= amount
-	if target.Health <= 0 {
-		target.Health = 0
-		// TODO: Mark as dead, broadcast kill
+
+	// Judge the hit against where the target was on the attacker's
+	// screen, but never further back than MaxRewind.
+	tx, ty := t.X, t.Y
+	var rewind time.Duration
+	if !in.ViewTime.IsZero() {
+		at := in.ViewTime
+		if earliest := now.Add(-rules.MaxRewind); at.Before(earliest) {
+			at = earliest
+		}
+		if at.After(now) {
+			at = now
+		}
+		rewind = now.Sub(at)
+		tx, ty = t.positionAt(at, now)
+	}
+
+	if dist := math.Hypot(tx-a.X, ty-a.Y); dist > rules.AttackRange {
+		return InputResult{Err: reject(a, RejectOutOfRange, "target was %.1f units away, range is %.1f", dist, rules.AttackRange)}
+	}
+
+	if wait := a.lastAttackAt.Add(rules.AttackCooldown).Sub(now); wait > 0 {
+		rejection := reject(a, RejectCooldown, "attack is on cooldown")
+		rejection.RetryAfter = wait
+		return InputResult{Err: rejection}
+	}
+
+	a.lastAttackAt = now
+	room.damage(a, t, rules.AttackDamage, now)
+	return InputResult{Player: a.State(), Target: t.State(), Rewind: rewind}
+}
+
+// result snapshots the room's outcome so far, with players placed by
+// their standing: the winner first, then by kills and fewest deaths.
+func (room *GameRoom) result(now time.Time) RoomResult {
+	result := RoomResult{
+		RoomID:   room.ID,
+		Mode:     room.Rules.Mode,
+		State:    string(room.State),
+		WinnerID: room.winner(),
+		EndedAt:  now,
+	}
+	if room.State == RoomActive || room.State == RoomFinished {
+		result.StartedAt = room.startedAt
+	}
+
+	for _, p := range room.Players {
+		result.Players = append(result.Players, p.result())
+	}
+	winner := result.WinnerID
+	sort.Slice(result.Players, func(i, j int) bool {
+		a, b := result.Players[i], result.Players[j]
+		if (a.PlayerID == winner) != (b.PlayerID == winner) {
+			return a.PlayerID == winner
+		}
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		if a.Deaths != b.Deaths {
+			return a.Deaths < b.Deaths
+		}
+		return a.PlayerID < b.PlayerID
+	})
+	for i := range result.Players {
+		p := &result.Players[i]
+		p.Placement = i + 1
+		if i > 0 {
+			prev := result.Players[i-1]
+			if p.PlayerID != winner && prev.PlayerID != winner && p.Kills == prev.Kills && p.Deaths == prev.Deaths {
+				p.Placement = prev.Placement
+			}
+		}
+	}
+
+	// Players who came back are placed by their standing in the room.
+	seen := make(map[string]bool)
+	for i := len(room.departed) - 1; i >= 0; i-- {
+		p := room.departed[i]
+		if _, back := room.Players[p.PlayerID]; back || seen[p.PlayerID] {
+			continue
+		}
+		seen[p.PlayerID] = true
+		p.Placement = len(room.Players) + 1
+		result.Players = append(result.Players, p)
+	}
+	return result
+}
+
+func (p *Player) result() PlayerResult {
+	return PlayerResult{
+		PlayerID:    p.ID,
+		Name:        p.Name,
+		X:           p.X,
+		Y:           p.Y,
+		Health:      p.Health,
+		Kills:       p.kills,
+		Deaths:      p.deaths,
+		DamageDealt: p.damageDealt,
+		DamageTaken: p.damageTaken,
 	}
-	return true
 }
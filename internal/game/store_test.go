@@ -0,0 +1,246 @@
+package game
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func rejection(t *testing.T, err error) *ActionError {
+	t.Helper()
+	var rejected *ActionError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("got %v, want an ActionError", err)
+	}
+	return rejected
+}
+
+// newTestManager returns a manager of its own for a test, playing by
+// DefaultRules as changed by tweak.
+func newTestManager(t *testing.T, tweak func(rules *Rules)) *RoomManager {
+	rules := DefaultRules
+	if tweak != nil {
+		tweak(&rules)
+	}
+	m := NewRoomManager(rules)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// inRoom runs fn on the room goroutine, as the room loop reads players
+// concurrently.
+func inRoom(t *testing.T, m *RoomManager, roomID string, fn func(room *GameRoom)) {
+	t.Helper()
+	if err := m.do(roomID, fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// move queues a move and waits for the tick that applies it.
+func move(m *RoomManager, roomID, playerID string, seq uint64, x, y float64) (PlayerState, error) {
+	res, err := wait(func(done func(InputResult)) error { return m.MovePlayer(roomID, playerID, seq, x, y, done) })
+	return res.Player, err
+}
+
+// attack queues an attack and waits for the tick that applies it.
+func attack(m *RoomManager, roomID, attackerID string, seq uint64, targetID string, viewTime time.Time) (InputResult, error) {
+	return wait(func(done func(InputResult)) error { return m.Attack(roomID, attackerID, seq, targetID, viewTime, done) })
+}
+
+func wait(submit func(done func(InputResult)) error) (InputResult, error) {
+	results := make(chan InputResult, 1)
+	if err := submit(func(res InputResult) { results <- res }); err != nil {
+		return InputResult{}, err
+	}
+	res := <-results
+	return res, res.Err
+}
+
+// startMatch puts a room straight into its active phase.
+func startMatch(t *testing.T, m *RoomManager, roomID string) {
+	t.Helper()
+	inRoom(t, m, roomID, func(room *GameRoom) { room.State = RoomActive })
+}
+
+func TestMovePlayerValidation(t *testing.T) {
+	m, roomID := newTestManager(t, nil), "r"
+	p := &Player{ID: "p1", Health: 100, X: 100, Y: 100}
+	m.AddPlayer(roomID, p)
+
+	// No time has passed since joining, so a long move is rejected and
+	// the authoritative position is reported back.
+	_, err := move(m, roomID, "p1", 0, 500, 500)
+	if r := rejection(t, err); r.Reason != RejectTooFast || r.Player.X != 100 || r.Player.Y != 100 {
+		t.Fatalf("got %+v", r)
+	}
+
+	inRoom(t, m, roomID, func(*GameRoom) { p.lastMoveAt = time.Now().Add(-time.Second) })
+	state, err := move(m, roomID, "p1", 0, 300, 100)
+	if err != nil || state.X != 300 {
+		t.Fatalf("one second at max speed: got %+v, %v", state, err)
+	}
+
+	// Standing still does not bank more than MaxMoveWindow.
+	inRoom(t, m, roomID, func(*GameRoom) { p.lastMoveAt = time.Now().Add(-time.Minute) })
+	if _, err := move(m, roomID, "p1", 0, 300, 600); rejection(t, err).Reason != RejectTooFast {
+		t.Fatal("long idle allowed a teleport")
+	}
+
+	if _, err := move(m, roomID, "p1", 0, -1, 100); rejection(t, err).Reason != RejectOutOfBounds {
+		t.Fatal("move off the map allowed")
+	}
+
+	if _, err := move(m, roomID, "nobody", 0, 0, 0); !errors.Is(err, ErrPlayerNotFound) {
+		t.Fatalf("unknown player: got %v", err)
+	}
+	if _, err := move(m, "no-such-room", "p1", 0, 0, 0); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("unknown room: got %v", err)
+	}
+}
+
+func TestAttackValidation(t *testing.T) {
+	m, roomID := newTestManager(t, nil), "r"
+	a := &Player{ID: "a", Health: 100, X: 0, Y: 0}
+	b := &Player{ID: "b", Health: 100, X: 30, Y: 40}
+	far := &Player{ID: "far", Health: 100, X: 500, Y: 500}
+	for _, p := range []*Player{a, b, far} {
+		m.AddPlayer(roomID, p)
+	}
+
+	if _, err := attack(m, roomID, "a", 0, "b", time.Time{}); rejection(t, err).Reason != RejectNotActive {
+		t.Fatal("attack allowed before the match started")
+	}
+	startMatch(t, m, roomID)
+
+	res, err := attack(m, roomID, "a", 0, "b", time.Time{})
+	if err != nil || res.Target.Health != 100-DefaultRules.AttackDamage || res.Player.ID != "a" {
+		t.Fatalf("got %+v %v", res, err)
+	}
+
+	r := rejection(t, func() error { _, err := attack(m, roomID, "a", 0, "b", time.Time{}); return err }())
+	if r.Reason != RejectCooldown || r.RetryAfter <= 0 {
+		t.Fatalf("second attack: got %+v", r)
+	}
+
+	inRoom(t, m, roomID, func(*GameRoom) { a.lastAttackAt = time.Time{} })
+	if _, err := attack(m, roomID, "a", 0, "far", time.Time{}); rejection(t, err).Reason != RejectOutOfRange {
+		t.Fatal("attack out of range allowed")
+	}
+	if _, err := attack(m, roomID, "a", 0, "a", time.Time{}); rejection(t, err).Reason != RejectSelfTarget {
+		t.Fatal("self attack allowed")
+	}
+	if _, err := attack(m, roomID, "a", 0, "ghost", time.Time{}); !errors.Is(err, ErrTargetNotFound) {
+		t.Fatalf("unknown target: got %v", err)
+	}
+}
+
+func TestInputSequencing(t *testing.T) {
+	m, roomID := newTestManager(t, nil), "r"
+	conn := &recordingConn{snapshots: make(chan RoomSnapshot, 64), deltas: make(chan RoomDelta, 64)}
+	p := &Player{ID: "p1", Health: 100, X: 100, Y: 100, Conn: conn}
+	m.AddPlayer(roomID, p)
+	defer m.RemovePlayer(roomID, p)
+
+	moveTo := func(seq uint64, x float64) error {
+		inRoom(t, m, roomID, func(*GameRoom) { p.lastMoveAt = time.Now().Add(-time.Second) })
+		_, err := move(m, roomID, "p1", seq, x, 100)
+		return err
+	}
+
+	if err := moveTo(5, 110); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveTo(5, 120); rejection(t, err).Reason != RejectStale {
+		t.Fatal("duplicate input applied")
+	}
+	if err := moveTo(3, 120); rejection(t, err).Reason != RejectStale {
+		t.Fatal("stale input applied")
+	}
+	// Rule rejections still count as processed.
+	if err := moveTo(6, 900); rejection(t, err).Reason != RejectTooFast {
+		t.Fatal("teleport allowed")
+	}
+	if err := moveTo(0, 120); err != nil {
+		t.Fatalf("unsequenced input: %v", err)
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case s := <-conn.snapshots:
+			if s.LastInputSeq == 6 && s.Players[0].X == 120 {
+				return
+			}
+		case <-deadline:
+			t.Fatal("no snapshot acknowledging input 6")
+		}
+	}
+}
+
+func TestUnknownRoomsAreNotCreated(t *testing.T) {
+	m := newTestManager(t, nil)
+
+	if _, err := move(m, "nowhere", "p1", 0, 1, 1); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("move: got %v", err)
+	}
+	if err := m.ApplyDamage("nowhere", "p1", 10); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("damage: got %v", err)
+	}
+	if err := m.SetReady("nowhere", "p1", true); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("ready: got %v", err)
+	}
+	if _, exists := m.find("nowhere"); exists {
+		t.Error("a command created the room")
+	}
+
+	// Managers do not share rooms.
+	other := newTestManager(t, nil)
+	p := &Player{ID: "p1", Health: 100}
+	if err := other.AddPlayer("r", p); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := m.find("r"); exists {
+		t.Error("room leaked into another manager")
+	}
+
+	other.Close()
+	if _, err := move(other, "r", "p1", 0, 1, 1); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("move after Close: got %v", err)
+	}
+	if err := other.AddPlayer("r", p); !errors.Is(err, ErrClosed) {
+		t.Errorf("join after Close: got %v", err)
+	}
+}
+
+func TestCloseEndsRunningMatches(t *testing.T) {
+	m := newTestManager(t, nil)
+	ended := make(chan RoomResult, 2)
+	m.OnMatchEnd(func(result RoomResult) {
+		time.Sleep(20 * time.Millisecond)
+		ended <- result
+	})
+
+	for _, id := range []string{"a1", "a2"} {
+		if err := m.AddPlayer("active", &Player{ID: id, Health: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	startMatch(t, m, "active")
+	inRoom(t, m, "active", func(room *GameRoom) { room.startedAt = time.Now() })
+	if err := m.AddPlayer("lobby", &Player{ID: "w1", Health: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+	select {
+	case result := <-ended:
+		if result.RoomID != "active" || result.Reason != ReasonServerShutdown || len(result.Players) != 2 {
+			t.Errorf("result = %+v, want the active room ended by %s", result, ReasonServerShutdown)
+		}
+	default:
+		t.Fatal("Close returned before OnMatchEnd recorded the running match")
+	}
+	if len(ended) > 0 {
+		t.Errorf("result for room %s, whose match never started", (<-ended).RoomID)
+	}
+}
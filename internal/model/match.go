@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Match records a finished match of a game room.
+type Match struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	RoomID   string             `bson:"roomId" json:"roomId"`
+	Mode     string             `bson:"mode" json:"mode"`
+	Reason   string             `bson:"reason" json:"reason"`
+	WinnerID string             `bson:"winnerId,omitempty" json:"winnerId,omitempty"`
+	// Participants lists every player ID, for looking matches up by user.
+	Participants []string      `bson:"participants" json:"participants"`
+	Teams        []MatchTeam   `bson:"teams" json:"teams"`
+	Players      []MatchPlayer `bson:"players" json:"players"`
+	StartedAt    time.Time     `bson:"startedAt" json:"startedAt"`
+	EndedAt      time.Time     `bson:"endedAt" json:"endedAt"`
+}
+
+type MatchTeam struct {
+	Name      string   `bson:"name" json:"name"`
+	PlayerIDs []string `bson:"playerIds" json:"playerIds"`
+}
+
+type MatchPlayer struct {
+	PlayerID    string `bson:"playerId" json:"playerId"`
+	Name        string `bson:"name" json:"name"`
+	Team        string `bson:"team" json:"team"`
+	Placement   int    `bson:"placement" json:"placement"`
+	Kills       int    `bson:"kills" json:"kills"`
+	Deaths      int    `bson:"deaths" json:"deaths"`
+	DamageDealt int    `bson:"damageDealt" json:"damageDealt"`
+	DamageTaken int    `bson:"damageTaken" json:"damageTaken"`
+	Left        bool   `bson:"left" json:"left"`
+}
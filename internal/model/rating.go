@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rating is a user's Glicko-2 skill rating in one game mode.
+type Rating struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	Mode       string             `bson:"mode" json:"mode"`
+	Rating     float64            `bson:"rating" json:"rating"`
+	Deviation  float64            `bson:"deviation" json:"deviation"`
+	Volatility float64            `bson:"volatility" json:"volatility"`
+	Games      int                `bson:"games" json:"games"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
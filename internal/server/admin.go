@@ -0,0 +1,33 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"os"
+	"time"
+)
+
+// NewAdminServer builds the internal admin server, which serves runtime
+// and game server metrics (queue depths, rooms, ...) as JSON on
+// /debug/vars. They include the command line and memory stats, so it
+// listens on ADMIN_ADDR, loopback only by default, rather than on the
+// public port. It returns nil when ADMIN_ADDR is "off".
+func NewAdminServer() *http.Server {
+	addr := os.Getenv("ADMIN_ADDR")
+	switch addr {
+	case "off":
+		return nil
+	case "":
+		addr = "127.0.0.1:6060"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminServerServesMetrics(t *testing.T) {
+	t.Setenv("ADMIN_ADDR", "")
+	admin := NewAdminServer()
+	if admin.Addr != "127.0.0.1:6060" {
+		t.Errorf("admin server listens on %q, want loopback", admin.Addr)
+	}
+
+	rr := httptest.NewRecorder()
+	admin.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("/debug/vars returned %d", rr.Code)
+	}
+
+	t.Setenv("ADMIN_ADDR", "off")
+	if NewAdminServer() != nil {
+		t.Error("admin server not disabled")
+	}
+}
@@ -37,15 +37,25 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	r.POST("/v1/auth/users/login", userController.LoginHandler)
 
+	ratingController := controller.NewRatingController(s.ratings)
+
+	r.GET("/v1/ratings/:userId", ratingController.ListRatingsHandler)
+	r.GET("/v1/ratings/:userId/:mode", ratingController.GetRatingHandler)
+
+	matchController := controller.NewMatchController(s.matches)
+
+	r.GET("/v1/users/:userId/matches", matchController.ListMatchesHandler)
+	r.GET("/v1/matches/:matchId", matchController.GetMatchHandler)
+
 	//authorized := r.Group("/v1/auth")
 	// authorized.Use(middleware.VerifyToken())
 	// {
 	// 	authorized.POST("/users/upload-image", userController.UploadImageHandler)
 	// }
 
-	// r.GET("/ws", func(c *gin.Context) {
-	// 	s.ws.HandleConnections(c.Writer, c.Request)
-	// })
+	r.GET("/ws", func(c *gin.Context) {
+		s.game.ServeWebSocket(c.Writer, c.Request)
+	})
 
 	return r
 }
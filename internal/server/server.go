@@ -11,17 +11,22 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"game_tcpserver/internal/database"
+	"game_tcpserver/internal/game"
 	"game_tcpserver/internal/service"
 	"game_tcpserver/internal/tcp"
 )
 
 type Server struct {
-	port int
-	db   *mongo.Database
-	//ws   *websocket.WebSocketServer
+	port    int
+	db      *mongo.Database
+	game    *tcp.Server
+	ratings *service.RatingService
+	matches *service.MatchService
 }
 
-func NewServer() *http.Server {
+// NewServer builds the HTTP server and starts the game server it shares
+// with the /ws gateway. Both must be shut down by the caller.
+func NewServer() (*http.Server, *tcp.Server) {
 	portStr := os.Getenv("PORT")
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
@@ -36,16 +41,45 @@ func NewServer() *http.Server {
 
 	// Initialize your services
 	conversationService := service.NewConversationService(db)
+	messageService := service.NewMessageService(db)
+	ratingService := service.NewRatingService(db)
+	matchService := service.NewMatchService(db)
 
-	// Start TCP server
-	go tcp.StartTCPServer(tcp.Dependencies{
-		ConversationService: conversationService,
+	// Finished matches are recorded and update the players' ratings;
+	// those cut short by a shutdown are recorded only
+	rooms := game.NewRoomManager(game.DefaultRules)
+	rooms.OnMatchEnd(func(result game.RoomResult) {
+		if _, err := matchService.SaveMatch(result); err != nil {
+			fmt.Printf("Error saving match in room %s: %v\n", result.RoomID, err)
+		}
+		if result.Reason == game.ReasonServerShutdown {
+			return
+		}
+		if err := ratingService.RecordMatch(result); err != nil {
+			fmt.Printf("Error rating match in room %s: %v\n", result.RoomID, err)
+		}
 	})
 
+	// The game server is shared by the TCP listener and the /ws gateway
+	gameServer := tcp.NewServer(tcp.Dependencies{
+		ConversationService: conversationService,
+		MessageService:      messageService,
+		Rooms:               rooms,
+		Ratings:             ratingService,
+	}, tcp.LoadConfig())
+
+	// Start TCP server
+	if err := gameServer.Start(); err != nil {
+		fmt.Printf("Error starting TCP server: %v\n", err)
+		os.Exit(1)
+	}
+
 	newServer := &Server{
-		port: port,
-		db:   db,
-		//ws:   ws,
+		port:    port,
+		db:      db,
+		game:    gameServer,
+		ratings: ratingService,
+		matches: matchService,
 	}
 
 	server := &http.Server{
@@ -56,5 +90,5 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	return server, gameServer
 }
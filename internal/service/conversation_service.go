@@ -172,3 +172,19 @@ func (s *ConversationService) AddUsersToGroupConversation(input AddParticipantsI
 
 	return nil
 }
+
+func (s *ConversationService) GetConversation(conversationID primitive.ObjectID) (*model.Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var convo model.Conversation
+	err := s.conversationCollection.FindOne(ctx, bson.M{"_id": conversationID}).Decode(&convo)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NewNotFoundError("conversation not found")
+		}
+		return nil, err
+	}
+
+	return &convo, nil
+}
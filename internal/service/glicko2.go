@@ -0,0 +1,108 @@
+package service
+
+import (
+	"math"
+
+	"game_tcpserver/internal/game"
+)
+
+// Glicko-2 as described by Glickman in "Example of the Glicko-2 system".
+// Ratings are kept on the Glicko scale and converted for the update.
+const (
+	glickoScale       = 173.7178
+	glickoTau         = 0.5 // how much volatility may change per period
+	glickoEpsilon     = 0.000001
+	DefaultRating     = game.DefaultRating
+	DefaultDeviation  = 350.0
+	DefaultVolatility = 0.06
+)
+
+// Glicko is a rating with its deviation and volatility.
+type Glicko struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+// GlickoOutcome is a game against Opponent scored 1 for a win, 0.5 for a
+// draw and 0 for a loss.
+type GlickoOutcome struct {
+	Opponent Glicko
+	Score    float64
+}
+
+// NewGlicko returns the rating of a player who has not played yet.
+func NewGlicko() Glicko {
+	return Glicko{Rating: DefaultRating, Deviation: DefaultDeviation, Volatility: DefaultVolatility}
+}
+
+// Update rates g after one rating period of games. With no games, only
+// the deviation grows.
+func (g Glicko) Update(games []GlickoOutcome) Glicko {
+	mu := (g.Rating - DefaultRating) / glickoScale
+	phi := g.Deviation / glickoScale
+
+	if len(games) == 0 {
+		phi = math.Sqrt(phi*phi + g.Volatility*g.Volatility)
+		return Glicko{Rating: g.Rating, Deviation: phi * glickoScale, Volatility: g.Volatility}
+	}
+
+	v, delta := 0.0, 0.0
+	for _, game := range games {
+		muJ := (game.Opponent.Rating - DefaultRating) / glickoScale
+		phiJ := game.Opponent.Deviation / glickoScale
+		gJ := 1 / math.Sqrt(1+3*phiJ*phiJ/(math.Pi*math.Pi))
+		e := 1 / (1 + math.Exp(-gJ*(mu-muJ)))
+		v += gJ * gJ * e * (1 - e)
+		delta += gJ * (game.Score - e)
+	}
+	v = 1 / v
+	delta *= v
+
+	sigma := volatility(phi, g.Volatility, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * delta / v
+
+	return Glicko{
+		Rating:     mu*glickoScale + DefaultRating,
+		Deviation:  phi * glickoScale,
+		Volatility: sigma,
+	}
+}
+
+// volatility finds the new volatility with the Illinois algorithm
+// (step 5 of the paper).
+func volatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-d)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/game"
+	"game_tcpserver/internal/model"
+)
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+func TestGlickoPaperExample(t *testing.T) {
+	player := Glicko{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	got := player.Update([]GlickoOutcome{
+		{Opponent: Glicko{Rating: 1400, Deviation: 30, Volatility: 0.06}, Score: 1},
+		{Opponent: Glicko{Rating: 1550, Deviation: 100, Volatility: 0.06}, Score: 0},
+		{Opponent: Glicko{Rating: 1700, Deviation: 300, Volatility: 0.06}, Score: 0},
+	})
+
+	if !near(got.Rating, 1464.06, 0.01) || !near(got.Deviation, 151.52, 0.01) || !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("update = %+v, want 1464.06 / 151.52 / 0.05999", got)
+	}
+}
+
+func TestGlickoWithoutGames(t *testing.T) {
+	player := Glicko{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	got := player.Update(nil)
+
+	if got.Rating != 1500 || got.Volatility != 0.06 || !near(got.Deviation, 200.27, 0.01) {
+		t.Errorf("update = %+v, want only the deviation to grow", got)
+	}
+}
+
+func TestGlickoWinnerGains(t *testing.T) {
+	a, b := NewGlicko(), NewGlicko()
+	a2 := a.Update([]GlickoOutcome{{Opponent: b, Score: 1}})
+	b2 := b.Update([]GlickoOutcome{{Opponent: a, Score: 0}})
+
+	if a2.Rating <= a.Rating || b2.Rating >= b.Rating || !near(a2.Rating-1500, 1500-b2.Rating, 1e-9) {
+		t.Errorf("winner %+v, loser %+v", a2, b2)
+	}
+	if a2.Deviation >= a.Deviation {
+		t.Errorf("deviation did not shrink after a game: %+v", a2)
+	}
+}
+
+func TestRateMatchByPlacement(t *testing.T) {
+	current := map[string]*model.Rating{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		current[id] = newRating(primitive.NewObjectID(), "deathmatch")
+	}
+	updated := rateMatch([]game.PlayerResult{
+		{PlayerID: "a", Placement: 1},
+		{PlayerID: "b", Placement: 2},
+		{PlayerID: "c", Placement: 2},
+		{PlayerID: "d", Placement: 4, Left: true},
+		{PlayerID: "guest", Placement: 1},
+	}, current)
+
+	if len(updated) != 4 {
+		t.Fatalf("rated %d players, want the 4 users", len(updated))
+	}
+	if !(updated["a"].Rating > updated["b"].Rating && updated["b"].Rating == updated["c"].Rating && updated["c"].Rating > updated["d"].Rating) {
+		t.Errorf("ratings do not follow placements: %+v", updated)
+	}
+	if current["a"].Rating != DefaultRating {
+		t.Error("current ratings were modified")
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"game_tcpserver/internal/game"
+	"game_tcpserver/internal/model"
+	"game_tcpserver/internal/utils"
+)
+
+// MaxMatchesPerPage caps the page size of ListMatches.
+const MaxMatchesPerPage = 100
+
+type MatchService struct {
+	collection *mongo.Collection
+}
+
+func NewMatchService(db *mongo.Database) *MatchService {
+	s := &MatchService{
+		collection: db.Collection("matches"),
+	}
+
+	// ListMatches looks up a user's matches, most recent first.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "participants", Value: 1}, {Key: "endedAt", Value: -1}},
+	})
+	if err != nil {
+		log.Printf("Could not create the matches index: %v", err)
+	}
+	return s
+}
+
+// SaveMatch records a finished match. Results of rooms whose match never
+// started are ignored.
+func (s *MatchService) SaveMatch(result game.RoomResult) (*model.Match, error) {
+	if result.StartedAt.IsZero() {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	match := newMatch(result)
+	if _, err := s.collection.InsertOne(ctx, match); err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
+
+// ListMatches returns page (from 1) of the matches userID played, most
+// recent first, along with the total number of them.
+func (s *MatchService) ListMatches(userID string, page, limit int) ([]model.Match, int64, error) {
+	if page < 1 || limit < 1 || limit > MaxMatchesPerPage {
+		return nil, 0, utils.NewBadRequestError("page must be at least 1 and limit between 1 and 100")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"participants": userID}
+	total, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "endedAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	matches := []model.Match{}
+	if err := cursor.All(ctx, &matches); err != nil {
+		return nil, 0, err
+	}
+	return matches, total, nil
+}
+
+func (s *MatchService) GetMatch(matchID primitive.ObjectID) (*model.Match, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var match model.Match
+	err := s.collection.FindOne(ctx, bson.M{"_id": matchID}).Decode(&match)
+	if err == mongo.ErrNoDocuments {
+		return nil, utils.NewNotFoundError("match not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
+
+// newMatch builds the record of result. Every mode is free-for-all for
+// now, so each player is a team of their own, named after them.
+func newMatch(result game.RoomResult) model.Match {
+	match := model.Match{
+		ID:        primitive.NewObjectID(),
+		RoomID:    result.RoomID,
+		Mode:      result.Mode,
+		Reason:    result.Reason,
+		WinnerID:  result.WinnerID,
+		StartedAt: result.StartedAt,
+		EndedAt:   result.EndedAt,
+	}
+	for _, p := range result.Players {
+		match.Participants = append(match.Participants, p.PlayerID)
+		match.Teams = append(match.Teams, model.MatchTeam{Name: p.PlayerID, PlayerIDs: []string{p.PlayerID}})
+		match.Players = append(match.Players, model.MatchPlayer{
+			PlayerID:    p.PlayerID,
+			Name:        p.Name,
+			Team:        p.PlayerID,
+			Placement:   p.Placement,
+			Kills:       p.Kills,
+			Deaths:      p.Deaths,
+			DamageDealt: p.DamageDealt,
+			DamageTaken: p.DamageTaken,
+			Left:        p.Left,
+		})
+	}
+	return match
+}
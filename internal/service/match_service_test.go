@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"game_tcpserver/internal/game"
+)
+
+func TestNewMatchRecordsEveryPlayer(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	match := newMatch(game.RoomResult{
+		RoomID:    "r",
+		Mode:      "deathmatch",
+		Reason:    game.ReasonTimeLimit,
+		WinnerID:  "a",
+		StartedAt: start,
+		EndedAt:   start.Add(time.Minute),
+		Players: []game.PlayerResult{
+			{PlayerID: "a", Placement: 1, Kills: 2, DamageDealt: 200},
+			{PlayerID: "b", Placement: 2, Deaths: 2, DamageTaken: 200, Left: true},
+		},
+	})
+
+	if len(match.Participants) != 2 || len(match.Teams) != 2 || match.Teams[1].PlayerIDs[0] != "b" {
+		t.Fatalf("participants %v, teams %+v", match.Participants, match.Teams)
+	}
+	if p := match.Players[0]; p.Team != "a" || p.Kills != 2 || p.DamageDealt != 200 {
+		t.Errorf("winner = %+v", p)
+	}
+	if p := match.Players[1]; !p.Left || p.DamageTaken != 200 {
+		t.Errorf("leaver = %+v", p)
+	}
+	if match.WinnerID != "a" || match.Reason != game.ReasonTimeLimit || !match.StartedAt.Equal(start) {
+		t.Errorf("match = %+v", match)
+	}
+}
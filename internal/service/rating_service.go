@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"game_tcpserver/internal/game"
+	"game_tcpserver/internal/model"
+	"game_tcpserver/internal/utils"
+)
+
+// RatingService keeps a Glicko-2 rating per user and game mode, updated
+// from the results of finished matches.
+type RatingService struct {
+	collection *mongo.Collection
+
+	// mu serializes RecordMatch so that two matches ending together do
+	// not both update from the same ratings.
+	mu sync.Mutex
+}
+
+func NewRatingService(db *mongo.Database) *RatingService {
+	return &RatingService{
+		collection: db.Collection("rating"),
+	}
+}
+
+// GetRating returns the rating of userID in mode, or the starting rating
+// with no games if the user has not played it yet.
+func (s *RatingService) GetRating(userID primitive.ObjectID, mode string) (*model.Rating, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var rating model.Rating
+	err := s.collection.FindOne(ctx, bson.M{"userId": userID, "mode": mode}).Decode(&rating)
+	if err == mongo.ErrNoDocuments {
+		return newRating(userID, mode), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// ListRatings returns the ratings of userID in every mode they played.
+func (s *RatingService) ListRatings(userID primitive.ObjectID) ([]model.Rating, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.M{"mode": 1}))
+	if err != nil {
+		return nil, err
+	}
+	ratings := []model.Rating{}
+	if err := cursor.All(ctx, &ratings); err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+// Ratings implements game.RatingLookup for matchmaking. Players without a
+// rating, or whose ID is not a user ID, are left out.
+func (s *RatingService) Ratings(mode string, playerIDs []string) (map[string]float64, error) {
+	ratings, err := s.find(mode, playerIDs)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64, len(ratings))
+	for id, r := range ratings {
+		out[id] = r.Rating
+	}
+	return out, nil
+}
+
+// RecordMatch rates everyone who played a finished match, left players
+// included. Each pair of players counts as one game, won by the better
+// placed player or drawn if they share a placement.
+func (s *RatingService) RecordMatch(result game.RoomResult) error {
+	if result.StartedAt.IsZero() || len(result.Players) < 2 {
+		return nil
+	}
+	if result.Mode == "" {
+		return utils.NewBadRequestError("match result has no game mode")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(result.Players))
+	for _, p := range result.Players {
+		ids = append(ids, p.PlayerID)
+	}
+	current, err := s.find(result.Mode, ids)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	for id, updated := range rateMatch(result.Players, current) {
+		rating := current[id]
+		rating.Rating = updated.Rating
+		rating.Deviation = updated.Deviation
+		rating.Volatility = updated.Volatility
+		rating.Games++
+		rating.UpdatedAt = now
+
+		_, err := s.collection.UpdateOne(ctx,
+			bson.M{"userId": rating.UserID, "mode": rating.Mode},
+			bson.M{
+				"$set": bson.M{
+					"rating":     rating.Rating,
+					"deviation":  rating.Deviation,
+					"volatility": rating.Volatility,
+					"games":      rating.Games,
+					"updatedAt":  rating.UpdatedAt,
+				},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find returns the ratings of playerIDs in mode, with the starting rating
+// for users who have none. IDs that are not user IDs are left out.
+func (s *RatingService) find(mode string, playerIDs []string) (map[string]*model.Rating, error) {
+	ratings := make(map[string]*model.Rating, len(playerIDs))
+	var userIDs []primitive.ObjectID
+	for _, id := range playerIDs {
+		userID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		ratings[id] = newRating(userID, mode)
+		userIDs = append(userIDs, userID)
+	}
+	if len(userIDs) == 0 {
+		return ratings, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := s.collection.Find(ctx, bson.M{"mode": mode, "userId": bson.M{"$in": userIDs}})
+	if err != nil {
+		return nil, err
+	}
+	var stored []model.Rating
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, err
+	}
+	for i := range stored {
+		ratings[stored[i].UserID.Hex()] = &stored[i]
+	}
+	return ratings, nil
+}
+
+func newRating(userID primitive.ObjectID, mode string) *model.Rating {
+	g := NewGlicko()
+	return &model.Rating{
+		UserID:     userID,
+		Mode:       mode,
+		Rating:     g.Rating,
+		Deviation:  g.Deviation,
+		Volatility: g.Volatility,
+	}
+}
+
+// rateMatch returns the new ratings of the players found in current,
+// all computed from their ratings before the match.
+func rateMatch(players []game.PlayerResult, current map[string]*model.Rating) map[string]Glicko {
+	glicko := func(r *model.Rating) Glicko {
+		return Glicko{Rating: r.Rating, Deviation: r.Deviation, Volatility: r.Volatility}
+	}
+
+	updated := make(map[string]Glicko, len(players))
+	for _, p := range players {
+		rating, ok := current[p.PlayerID]
+		if !ok {
+			continue
+		}
+		var games []GlickoOutcome
+		for _, o := range players {
+			opponent, ok := current[o.PlayerID]
+			if !ok || o.PlayerID == p.PlayerID {
+				continue
+			}
+			score := 0.5
+			if p.Placement < o.Placement {
+				score = 1
+			} else if p.Placement > o.Placement {
+				score = 0
+			}
+			games = append(games, GlickoOutcome{Opponent: glicko(opponent), Score: score})
+		}
+		if len(games) > 0 {
+			updated[p.PlayerID] = glicko(rating).Update(games)
+		}
+	}
+	return updated
+}
@@ -0,0 +1,157 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Codec encodes the protocol messages of a session. Every codec carries the
+// same schema: payload structs are described by their json tags, and the
+// router and handlers never see the wire encoding.
+type Codec interface {
+	Name() string
+	// DecodeEnvelope decodes a client frame. The payload stays encoded and is
+	// decoded later through Envelope.Bind.
+	DecodeEnvelope(frame []byte) (Envelope, error)
+	// Unmarshal decodes a payload into v.
+	Unmarshal(payload []byte, v interface{}) error
+	// Marshal encodes a Reply or an Event sent to the client.
+	Marshal(v interface{}) ([]byte, error)
+	// Binary codecs may emit any byte and need length-prefixed framing.
+	Binary() bool
+}
+
+// Names accepted by the set_codec command.
+const (
+	CodecJSON     = "json"
+	CodecMsgpack  = "msgpack"
+	CodecProtobuf = "protobuf"
+)
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+var codecs = map[string]Codec{
+	CodecJSON:     JSONCodec,
+	CodecMsgpack:  MsgpackCodec,
+	CodecProtobuf: ProtobufCodec,
+}
+
+// CodecByName returns the codec registered under name.
+func CodecByName(name string) (Codec, bool) {
+	c, ok := codecs[name]
+	return c, ok
+}
+
+type jsonCodec struct{}
+
+type jsonEnvelope struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+func (jsonCodec) Name() string { return CodecJSON }
+func (jsonCodec) Binary() bool { return false }
+
+func (c jsonCodec) DecodeEnvelope(frame []byte) (Envelope, error) {
+	var env jsonEnvelope
+	if err := json.Unmarshal(frame, &env); err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Type: env.Type, RequestID: env.RequestID, Payload: env.Payload, codec: c}, nil
+}
+
+func (jsonCodec) Unmarshal(payload []byte, v interface{}) error {
+	return json.Unmarshal(payload, v)
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// msgpackCodec uses the json struct tags so payloads keep the same field
+// names as in JSON. ObjectIDs and times are strings as in JSON too, see
+// init.
+type msgpackCodec struct{}
+
+// msgpack would send ObjectIDs as 12-byte binaries and times as timestamp
+// extensions. Its type hooks are process-wide; only this codec uses it.
+func init() {
+	msgpack.Register(primitive.ObjectID{},
+		func(e *msgpack.Encoder, v reflect.Value) error {
+			return e.EncodeString(v.Interface().(primitive.ObjectID).Hex())
+		},
+		func(d *msgpack.Decoder, v reflect.Value) error {
+			s, err := d.DecodeString()
+			if err != nil {
+				return err
+			}
+			id := primitive.NilObjectID
+			if s != "" {
+				if id, err = primitive.ObjectIDFromHex(s); err != nil {
+					return err
+				}
+			}
+			v.Set(reflect.ValueOf(id))
+			return nil
+		})
+
+	msgpack.Register(time.Time{},
+		func(e *msgpack.Encoder, v reflect.Value) error {
+			return e.EncodeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		},
+		func(d *msgpack.Decoder, v reflect.Value) error {
+			s, err := d.DecodeString()
+			if err != nil {
+				return err
+			}
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		})
+}
+
+type msgpackEnvelope struct {
+	Type      string             `msgpack:"type"`
+	RequestID string             `msgpack:"requestId,omitempty"`
+	Payload   msgpack.RawMessage `msgpack:"payload,omitempty"`
+}
+
+func (msgpackCodec) Name() string { return CodecMsgpack }
+func (msgpackCodec) Binary() bool { return true }
+
+func (c msgpackCodec) DecodeEnvelope(frame []byte) (Envelope, error) {
+	var env msgpackEnvelope
+	if err := msgpack.Unmarshal(frame, &env); err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Type: env.Type, RequestID: env.RequestID, Payload: env.Payload, codec: c}, nil
+}
+
+func (msgpackCodec) Unmarshal(payload []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(payload))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
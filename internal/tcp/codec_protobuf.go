@@ -0,0 +1,456 @@
+package tcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"game_tcpserver/internal/game"
+)
+
+// protobufCodec implements the messages of protocol.proto field by field.
+// Commands, replies and events with a typed message are encoded directly;
+// other payloads are google.protobuf.Value messages converted to and from
+// the JSON schema of the handlers.
+type protobufCodec struct{}
+
+// Field numbers from protocol.proto
+const (
+	pbEnvelopeType      protowire.Number = 1
+	pbEnvelopeRequestID protowire.Number = 2
+	pbEnvelopePayload   protowire.Number = 3
+	pbEnvelopeMove      protowire.Number = 4
+	pbEnvelopeAttack    protowire.Number = 5
+	pbEnvelopeAckState  protowire.Number = 6
+
+	pbMoveRoomID protowire.Number = 1
+	pbMoveSeq    protowire.Number = 2
+	pbMoveX      protowire.Number = 3
+	pbMoveY      protowire.Number = 4
+
+	pbAttackRoomID   protowire.Number = 1
+	pbAttackSeq      protowire.Number = 2
+	pbAttackTargetID protowire.Number = 3
+	pbAttackViewTime protowire.Number = 4
+
+	pbAckStateRoomID protowire.Number = 1
+	pbAckStateTick   protowire.Number = 2
+
+	pbReplyRequestID protowire.Number = 1
+	pbReplyOK        protowire.Number = 2
+	pbReplyError     protowire.Number = 3
+	pbReplyData      protowire.Number = 4
+	pbReplyPlayer    protowire.Number = 5
+	pbReplyAttack    protowire.Number = 6
+
+	pbErrorCode    protowire.Number = 1
+	pbErrorMessage protowire.Number = 2
+	pbErrorDetails protowire.Number = 3
+
+	pbEventType      protowire.Number = 1
+	pbEventData      protowire.Number = 2
+	pbEventSeq       protowire.Number = 3
+	pbEventRoomState protowire.Number = 4
+	pbEventRoomDelta protowire.Number = 5
+
+	pbPlayerID     protowire.Number = 1
+	pbPlayerName   protowire.Number = 2
+	pbPlayerX      protowire.Number = 3
+	pbPlayerY      protowire.Number = 4
+	pbPlayerHealth protowire.Number = 5
+	pbPlayerReady  protowire.Number = 6
+	pbPlayerKills  protowire.Number = 7
+	pbPlayerDeaths protowire.Number = 8
+
+	pbAttackResultAttacker protowire.Number = 1
+	pbAttackResultTarget   protowire.Number = 2
+	pbAttackResultRewindMs protowire.Number = 3
+
+	pbSnapshotRoomID       protowire.Number = 1
+	pbSnapshotTick         protowire.Number = 2
+	pbSnapshotServerTime   protowire.Number = 3
+	pbSnapshotState        protowire.Number = 4
+	pbSnapshotPlayers      protowire.Number = 5
+	pbSnapshotLastInputSeq protowire.Number = 6
+
+	pbPlayerDeltaID     protowire.Number = 1
+	pbPlayerDeltaX      protowire.Number = 2
+	pbPlayerDeltaY      protowire.Number = 3
+	pbPlayerDeltaHealth protowire.Number = 4
+	pbPlayerDeltaReady  protowire.Number = 5
+	pbPlayerDeltaKills  protowire.Number = 6
+	pbPlayerDeltaDeaths protowire.Number = 7
+
+	pbDeltaRoomID       protowire.Number = 1
+	pbDeltaTick         protowire.Number = 2
+	pbDeltaBaseTick     protowire.Number = 3
+	pbDeltaServerTime   protowire.Number = 4
+	pbDeltaState        protowire.Number = 5
+	pbDeltaChanged      protowire.Number = 6
+	pbDeltaJoined       protowire.Number = 7
+	pbDeltaLeft         protowire.Number = 8
+	pbDeltaLastInputSeq protowire.Number = 9
+
+	pbServerReply protowire.Number = 1
+	pbServerEvent protowire.Number = 2
+)
+
+func (protobufCodec) Name() string { return CodecProtobuf }
+func (protobufCodec) Binary() bool { return true }
+
+// DecodeEnvelope keeps the tag with the payload, so Unmarshal knows which
+// message of the body it holds.
+func (c protobufCodec) DecodeEnvelope(frame []byte) (Envelope, error) {
+	env := Envelope{codec: c}
+	err := consumePbFields(frame, func(num protowire.Number, field []byte, _ uint64, raw []byte) {
+		switch num {
+		case pbEnvelopeType:
+			env.Type = string(raw)
+		case pbEnvelopeRequestID:
+			env.RequestID = string(raw)
+		case pbEnvelopePayload, pbEnvelopeMove, pbEnvelopeAttack, pbEnvelopeAckState:
+			env.Payload = field
+		}
+	})
+	if err != nil {
+		return Envelope{}, err
+	}
+	return env, nil
+}
+
+func (protobufCodec) Unmarshal(payload []byte, v interface{}) error {
+	num, typ, n := protowire.ConsumeTag(payload)
+	if n < 0 {
+		return protowire.ParseError(n)
+	}
+	if typ != protowire.BytesType {
+		return fmt.Errorf("protobuf codec: payload field %d is not a message", num)
+	}
+	msg, n := protowire.ConsumeBytes(payload[n:])
+	if n < 0 {
+		return protowire.ParseError(n)
+	}
+
+	switch num {
+	case pbEnvelopePayload:
+		return unmarshalPbValue(msg, v)
+	case pbEnvelopeMove:
+		if cmd, ok := v.(*TCPMove); ok {
+			return unmarshalPbMove(msg, cmd)
+		}
+	case pbEnvelopeAttack:
+		if cmd, ok := v.(*TCPAttack); ok {
+			return unmarshalPbAttack(msg, cmd)
+		}
+	case pbEnvelopeAckState:
+		if cmd, ok := v.(*TCPAckState); ok {
+			return unmarshalPbAckState(msg, cmd)
+		}
+	}
+	return fmt.Errorf("protobuf codec: payload field %d does not fit %T", num, v)
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	var b []byte
+	switch msg := v.(type) {
+	case Reply:
+		reply, err := marshalPbReply(msg)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, pbServerReply, protowire.BytesType)
+		b = protowire.AppendBytes(b, reply)
+	case Event:
+		event, err := marshalPbEvent(msg)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, pbServerEvent, protowire.BytesType)
+		b = protowire.AppendBytes(b, event)
+	default:
+		return nil, fmt.Errorf("protobuf codec: cannot encode %T", v)
+	}
+	return b, nil
+}
+
+func marshalPbReply(r Reply) ([]byte, error) {
+	var b []byte
+	b = appendPbString(b, pbReplyRequestID, r.RequestID)
+	b = appendPbBool(b, pbReplyOK, r.OK)
+	if r.Error != nil {
+		var e []byte
+		e = protowire.AppendTag(e, pbErrorCode, protowire.BytesType)
+		e = protowire.AppendString(e, r.Error.Code)
+		e = protowire.AppendTag(e, pbErrorMessage, protowire.BytesType)
+		e = protowire.AppendString(e, r.Error.Message)
+		if len(r.Error.Details) > 0 {
+			var err error
+			if e, err = appendPbValue(e, pbErrorDetails, r.Error.Details); err != nil {
+				return nil, err
+			}
+		}
+
+		b = appendPbMessage(b, pbReplyError, e)
+	}
+
+	switch data := r.Data.(type) {
+	case game.PlayerState:
+		return appendPbMessage(b, pbReplyPlayer, appendPbPlayer(nil, data)), nil
+	case AttackResult:
+		var result []byte
+		result = appendPbMessage(result, pbAttackResultAttacker, appendPbPlayer(nil, data.Attacker))
+		result = appendPbMessage(result, pbAttackResultTarget, appendPbPlayer(nil, data.Target))
+		result = appendPbInt(result, pbAttackResultRewindMs, data.RewindMs)
+		return appendPbMessage(b, pbReplyAttack, result), nil
+	}
+	return appendPbValue(b, pbReplyData, r.Data)
+}
+
+func marshalPbEvent(ev Event) ([]byte, error) {
+	var b []byte
+	b = protowire.AppendTag(b, pbEventType, protowire.BytesType)
+	b = protowire.AppendString(b, ev.Type)
+	b = appendPbUint(b, pbEventSeq, ev.Seq)
+
+	switch data := ev.Data.(type) {
+	case game.RoomSnapshot:
+		return appendPbMessage(b, pbEventRoomState, appendPbSnapshot(nil, data)), nil
+	case game.RoomDelta:
+		return appendPbMessage(b, pbEventRoomDelta, appendPbDelta(nil, data)), nil
+	}
+	return appendPbValue(b, pbEventData, ev.Data)
+}
+
+func unmarshalPbMove(msg []byte, cmd *TCPMove) error {
+	return consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbMoveRoomID:
+			cmd.RoomID = string(raw)
+		case pbMoveSeq:
+			cmd.Seq = v
+		case pbMoveX:
+			cmd.X = math.Float64frombits(v)
+		case pbMoveY:
+			cmd.Y = math.Float64frombits(v)
+		}
+	})
+}
+
+func unmarshalPbAttack(msg []byte, cmd *TCPAttack) error {
+	return consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbAttackRoomID:
+			cmd.RoomID = string(raw)
+		case pbAttackSeq:
+			cmd.Seq = v
+		case pbAttackTargetID:
+			cmd.TargetID = string(raw)
+		case pbAttackViewTime:
+			cmd.ViewTime = int64(v)
+		}
+	})
+}
+
+func unmarshalPbAckState(msg []byte, cmd *TCPAckState) error {
+	return consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbAckStateRoomID:
+			cmd.RoomID = string(raw)
+		case pbAckStateTick:
+			cmd.Tick = v
+		}
+	})
+}
+
+func appendPbPlayer(b []byte, p game.PlayerState) []byte {
+	b = appendPbString(b, pbPlayerID, p.ID)
+	b = appendPbString(b, pbPlayerName, p.Name)
+	b = appendPbDouble(b, pbPlayerX, p.X)
+	b = appendPbDouble(b, pbPlayerY, p.Y)
+	b = appendPbInt(b, pbPlayerHealth, int64(p.Health))
+	b = appendPbBool(b, pbPlayerReady, p.Ready)
+	b = appendPbInt(b, pbPlayerKills, int64(p.Kills))
+	return appendPbInt(b, pbPlayerDeaths, int64(p.Deaths))
+}
+
+func appendPbSnapshot(b []byte, s game.RoomSnapshot) []byte {
+	b = appendPbString(b, pbSnapshotRoomID, s.RoomID)
+	b = appendPbUint(b, pbSnapshotTick, s.Tick)
+	b = appendPbInt(b, pbSnapshotServerTime, s.ServerTime)
+	b = appendPbString(b, pbSnapshotState, string(s.State))
+	for _, p := range s.Players {
+		b = appendPbMessage(b, pbSnapshotPlayers, appendPbPlayer(nil, p))
+	}
+	return appendPbUint(b, pbSnapshotLastInputSeq, s.LastInputSeq)
+}
+
+func appendPbDelta(b []byte, d game.RoomDelta) []byte {
+	b = appendPbString(b, pbDeltaRoomID, d.RoomID)
+	b = appendPbUint(b, pbDeltaTick, d.Tick)
+	b = appendPbUint(b, pbDeltaBaseTick, d.BaseTick)
+	b = appendPbInt(b, pbDeltaServerTime, d.ServerTime)
+	b = appendPbString(b, pbDeltaState, string(d.State))
+	for _, p := range d.Changed {
+		b = appendPbMessage(b, pbDeltaChanged, appendPbPlayerDelta(nil, p))
+	}
+	for _, p := range d.Joined {
+		b = appendPbMessage(b, pbDeltaJoined, appendPbPlayer(nil, p))
+	}
+	for _, id := range d.Left {
+		b = protowire.AppendTag(b, pbDeltaLeft, protowire.BytesType)
+		b = protowire.AppendString(b, id)
+	}
+	return appendPbUint(b, pbDeltaLastInputSeq, d.LastInputSeq)
+}
+
+// appendPbPlayerDelta writes every field that is set, even at its zero
+// value, since an absent field means unchanged.
+func appendPbPlayerDelta(b []byte, d game.PlayerDelta) []byte {
+	b = appendPbString(b, pbPlayerDeltaID, d.ID)
+	if d.X != nil {
+		b = protowire.AppendTag(b, pbPlayerDeltaX, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*d.X))
+	}
+	if d.Y != nil {
+		b = protowire.AppendTag(b, pbPlayerDeltaY, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*d.Y))
+	}
+	if d.Health != nil {
+		b = protowire.AppendTag(b, pbPlayerDeltaHealth, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(*d.Health)))
+	}
+	if d.Ready != nil {
+		b = protowire.AppendTag(b, pbPlayerDeltaReady, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(*d.Ready))
+	}
+	if d.Kills != nil {
+		b = protowire.AppendTag(b, pbPlayerDeltaKills, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(*d.Kills)))
+	}
+	if d.Deaths != nil {
+		b = protowire.AppendTag(b, pbPlayerDeltaDeaths, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(*d.Deaths)))
+	}
+	return b
+}
+
+// The appendPb helpers leave out zero values, as proto3 does.
+
+func appendPbString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendPbUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendPbInt encodes int32 and int64 fields; negative int32 values are
+// sign-extended to ten bytes like int64 ones.
+func appendPbInt(b []byte, num protowire.Number, v int64) []byte {
+	return appendPbUint(b, num, uint64(v))
+}
+
+func appendPbBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendPbDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendPbMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// consumePbFields calls f with every field of msg: field is the whole
+// encoded field, v holds varint and fixed values and raw the contents of
+// length-delimited ones.
+func consumePbFields(msg []byte, f func(num protowire.Number, field []byte, v uint64, raw []byte)) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		m := protowire.ConsumeFieldValue(num, typ, msg[n:])
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		field, value := msg[:n+m], msg[n:n+m]
+		msg = msg[n+m:]
+
+		var v uint64
+		var raw []byte
+		switch typ {
+		case protowire.VarintType:
+			v, _ = protowire.ConsumeVarint(value)
+		case protowire.Fixed64Type:
+			v, _ = protowire.ConsumeFixed64(value)
+		case protowire.Fixed32Type:
+			v32, _ := protowire.ConsumeFixed32(value)
+			v = uint64(v32)
+		case protowire.BytesType:
+			raw, _ = protowire.ConsumeBytes(value)
+		}
+		f(num, field, v, raw)
+	}
+	return nil
+}
+
+// unmarshalPbValue decodes a google.protobuf.Value through its JSON form
+// into the handler's payload struct.
+func unmarshalPbValue(msg []byte, v interface{}) error {
+	var value structpb.Value
+	if err := proto.Unmarshal(msg, &value); err != nil {
+		return err
+	}
+	data, err := protojson.Marshal(&value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// appendPbValue appends data as a google.protobuf.Value field, going
+// through its JSON form so the schema matches the other codecs.
+func appendPbValue(b []byte, num protowire.Number, data interface{}) ([]byte, error) {
+	if data == nil {
+		return b, nil
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var value structpb.Value
+	if err := protojson.Unmarshal(js, &value); err != nil {
+		return nil, err
+	}
+	encoded, err := proto.Marshal(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendPbMessage(b, num, encoded), nil
+}
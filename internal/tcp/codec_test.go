@@ -0,0 +1,545 @@
+package tcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"game_tcpserver/internal/game"
+	"game_tcpserver/internal/model"
+)
+
+// bigSeq does not survive a trip through float64.
+const bigSeq = 1<<60 + 1
+
+var allCodecs = []Codec{JSONCodec, MsgpackCodec, ProtobufCodec}
+
+// encodeCommand encodes an envelope the way a client of codec does. A nil
+// payload is left out.
+func encodeCommand(t *testing.T, codec Codec, cmdType, requestID string, payload interface{}) []byte {
+	t.Helper()
+
+	var frame []byte
+	var p []byte
+	var err error
+	switch codec {
+	case JSONCodec:
+		if payload != nil {
+			p, err = json.Marshal(payload)
+		}
+		if err == nil {
+			frame, err = json.Marshal(jsonEnvelope{Type: cmdType, RequestID: requestID, Payload: p})
+		}
+	case MsgpackCodec:
+		if payload != nil {
+			p, err = MsgpackCodec.Marshal(payload)
+		}
+		if err == nil {
+			frame, err = msgpack.Marshal(msgpackEnvelope{Type: cmdType, RequestID: requestID, Payload: p})
+		}
+	case ProtobufCodec:
+		frame = appendPbString(nil, pbEnvelopeType, cmdType)
+		frame = appendPbString(frame, pbEnvelopeRequestID, requestID)
+		switch p := payload.(type) {
+		case TCPMove:
+			var msg []byte
+			msg = appendPbString(msg, pbMoveRoomID, p.RoomID)
+			msg = appendPbUint(msg, pbMoveSeq, p.Seq)
+			msg = appendPbDouble(msg, pbMoveX, p.X)
+			msg = appendPbDouble(msg, pbMoveY, p.Y)
+			frame = appendPbMessage(frame, pbEnvelopeMove, msg)
+		case TCPAttack:
+			var msg []byte
+			msg = appendPbString(msg, pbAttackRoomID, p.RoomID)
+			msg = appendPbUint(msg, pbAttackSeq, p.Seq)
+			msg = appendPbString(msg, pbAttackTargetID, p.TargetID)
+			msg = appendPbInt(msg, pbAttackViewTime, p.ViewTime)
+			frame = appendPbMessage(frame, pbEnvelopeAttack, msg)
+		case TCPAckState:
+			var msg []byte
+			msg = appendPbString(msg, pbAckStateRoomID, p.RoomID)
+			msg = appendPbUint(msg, pbAckStateTick, p.Tick)
+			frame = appendPbMessage(frame, pbEnvelopeAckState, msg)
+		default:
+			frame, err = appendPbValue(frame, pbEnvelopePayload, payload)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestCodecsRoundTripCommands(t *testing.T) {
+	commands := map[string]interface{}{
+		"move":      TCPMove{RoomID: "r1", Seq: bigSeq, X: 1.5, Y: -2},
+		"attack":    TCPAttack{RoomID: "r1", Seq: bigSeq + 1, TargetID: "p2", ViewTime: 1700000000123},
+		"ack_state": TCPAckState{RoomID: "r1", Tick: bigSeq + 2},
+		"ready":     TCPReady{RoomID: "r1", Ready: true},
+	}
+
+	for _, codec := range allCodecs {
+		for cmdType, want := range commands {
+			env, err := codec.DecodeEnvelope(encodeCommand(t, codec, cmdType, "1", want))
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.Name(), cmdType, err)
+			}
+			if env.Type != cmdType || env.RequestID != "1" {
+				t.Errorf("%s %s: envelope = %q %q", codec.Name(), cmdType, env.Type, env.RequestID)
+			}
+
+			got := reflect.New(reflect.TypeOf(want))
+			if err := env.Bind(got.Interface()); err != nil {
+				t.Fatalf("%s %s: %v", codec.Name(), cmdType, err)
+			}
+			if !reflect.DeepEqual(got.Elem().Interface(), want) {
+				t.Errorf("%s %s: got %+v, want %+v", codec.Name(), cmdType, got.Elem().Interface(), want)
+			}
+		}
+	}
+
+	// A typed payload only binds to its own command.
+	env, _ := ProtobufCodec.DecodeEnvelope(encodeCommand(t, ProtobufCodec, "attack", "1", commands["move"]))
+	if err := env.Bind(&TCPAttack{}); err == nil {
+		t.Error("a move payload bound to an attack")
+	}
+}
+
+// serverFrame holds what a client decodes from a Reply or an Event.
+type serverFrame struct {
+	Type      string      `json:"type"`
+	Seq       uint64      `json:"seq"`
+	RequestID string      `json:"requestId"`
+	OK        bool        `json:"ok"`
+	Error     *ReplyError `json:"error"`
+	Data      interface{} `json:"data"`
+}
+
+// decodeServerFrame decodes frame, with its data into the pointer data.
+func decodeServerFrame(t *testing.T, codec Codec, frame []byte, data interface{}) serverFrame {
+	t.Helper()
+
+	got := serverFrame{Data: data}
+	if codec != ProtobufCodec {
+		if err := codec.Unmarshal(frame, &got); err != nil {
+			t.Fatalf("%s: %v", codec.Name(), err)
+		}
+		return got
+	}
+
+	var kind protowire.Number
+	var body []byte
+	must(t, consumePbFields(frame, func(num protowire.Number, _ []byte, _ uint64, raw []byte) { kind, body = num, raw }))
+	if kind == pbServerReply {
+		must(t, consumePbFields(body, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+			switch num {
+			case pbReplyRequestID:
+				got.RequestID = string(raw)
+			case pbReplyOK:
+				got.OK = v != 0
+			case pbReplyError:
+				got.Error = &ReplyError{}
+				must(t, consumePbFields(raw, func(num protowire.Number, _ []byte, _ uint64, raw []byte) {
+					switch num {
+					case pbErrorCode:
+						got.Error.Code = string(raw)
+					case pbErrorMessage:
+						got.Error.Message = string(raw)
+					}
+				}))
+			case pbReplyData:
+				must(t, unmarshalPbValue(raw, data))
+			case pbReplyPlayer:
+				*data.(*game.PlayerState) = pbPlayer(t, raw)
+			case pbReplyAttack:
+				*data.(*AttackResult) = pbAttackResult(t, raw)
+			}
+		}))
+		return got
+	}
+	must(t, consumePbFields(body, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbEventType:
+			got.Type = string(raw)
+		case pbEventSeq:
+			got.Seq = v
+		case pbEventData:
+			must(t, unmarshalPbValue(raw, data))
+		case pbEventRoomState:
+			*data.(*game.RoomSnapshot) = pbSnapshot(t, raw)
+		case pbEventRoomDelta:
+			*data.(*game.RoomDelta) = pbDelta(t, raw)
+		}
+	}))
+	return got
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pbPlayer(t *testing.T, msg []byte) game.PlayerState {
+	var p game.PlayerState
+	must(t, consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbPlayerID:
+			p.ID = string(raw)
+		case pbPlayerName:
+			p.Name = string(raw)
+		case pbPlayerX:
+			p.X = math.Float64frombits(v)
+		case pbPlayerY:
+			p.Y = math.Float64frombits(v)
+		case pbPlayerHealth:
+			p.Health = int(int32(v))
+		case pbPlayerReady:
+			p.Ready = v != 0
+		case pbPlayerKills:
+			p.Kills = int(int32(v))
+		case pbPlayerDeaths:
+			p.Deaths = int(int32(v))
+		}
+	}))
+	return p
+}
+
+func pbAttackResult(t *testing.T, msg []byte) AttackResult {
+	var r AttackResult
+	must(t, consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbAttackResultAttacker:
+			r.Attacker = pbPlayer(t, raw)
+		case pbAttackResultTarget:
+			r.Target = pbPlayer(t, raw)
+		case pbAttackResultRewindMs:
+			r.RewindMs = int64(v)
+		}
+	}))
+	return r
+}
+
+func pbSnapshot(t *testing.T, msg []byte) game.RoomSnapshot {
+	var s game.RoomSnapshot
+	must(t, consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbSnapshotRoomID:
+			s.RoomID = string(raw)
+		case pbSnapshotTick:
+			s.Tick = v
+		case pbSnapshotServerTime:
+			s.ServerTime = int64(v)
+		case pbSnapshotState:
+			s.State = game.RoomState(raw)
+		case pbSnapshotPlayers:
+			s.Players = append(s.Players, pbPlayer(t, raw))
+		case pbSnapshotLastInputSeq:
+			s.LastInputSeq = v
+		}
+	}))
+	return s
+}
+
+func pbDelta(t *testing.T, msg []byte) game.RoomDelta {
+	var d game.RoomDelta
+	must(t, consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbDeltaRoomID:
+			d.RoomID = string(raw)
+		case pbDeltaTick:
+			d.Tick = v
+		case pbDeltaBaseTick:
+			d.BaseTick = v
+		case pbDeltaServerTime:
+			d.ServerTime = int64(v)
+		case pbDeltaState:
+			d.State = game.RoomState(raw)
+		case pbDeltaChanged:
+			d.Changed = append(d.Changed, pbPlayerDelta(t, raw))
+		case pbDeltaJoined:
+			d.Joined = append(d.Joined, pbPlayer(t, raw))
+		case pbDeltaLeft:
+			d.Left = append(d.Left, string(raw))
+		case pbDeltaLastInputSeq:
+			d.LastInputSeq = v
+		}
+	}))
+	return d
+}
+
+func pbPlayerDelta(t *testing.T, msg []byte) game.PlayerDelta {
+	var d game.PlayerDelta
+	integer := func(v uint64) *int { i := int(int32(v)); return &i }
+	must(t, consumePbFields(msg, func(num protowire.Number, _ []byte, v uint64, raw []byte) {
+		switch num {
+		case pbPlayerDeltaID:
+			d.ID = string(raw)
+		case pbPlayerDeltaX:
+			x := math.Float64frombits(v)
+			d.X = &x
+		case pbPlayerDeltaY:
+			y := math.Float64frombits(v)
+			d.Y = &y
+		case pbPlayerDeltaHealth:
+			d.Health = integer(v)
+		case pbPlayerDeltaReady:
+			ready := v != 0
+			d.Ready = &ready
+		case pbPlayerDeltaKills:
+			d.Kills = integer(v)
+		case pbPlayerDeltaDeaths:
+			d.Deaths = integer(v)
+		}
+	}))
+	return d
+}
+
+func TestCodecsRoundTripServerMessages(t *testing.T) {
+	x, health, ready := 0.0, 0, false
+	player := game.PlayerState{ID: "p1", Name: "Ann", X: 1.5, Y: -2, Health: 100, Ready: true, Kills: 2, Deaths: 1}
+	target := game.PlayerState{ID: "p2", Name: "Bo", Health: -5}
+
+	tests := []struct {
+		name string
+		msg  interface{}
+		want serverFrame
+	}{
+		{"room.state", Event{Type: EventRoomState, Data: game.RoomSnapshot{
+			RoomID: "r1", Tick: bigSeq, ServerTime: 1700000000123, State: game.RoomActive,
+			Players: []game.PlayerState{player, target}, LastInputSeq: bigSeq + 1,
+		}}, serverFrame{Type: EventRoomState}},
+		{"room.delta", Event{Type: EventRoomDelta, Data: game.RoomDelta{
+			RoomID: "r1", Tick: bigSeq + 3, BaseTick: bigSeq, ServerTime: 1700000000123, State: game.RoomFinished,
+			Changed: []game.PlayerDelta{{ID: "p1", X: &x, Health: &health, Ready: &ready}},
+			Joined:  []game.PlayerState{target}, Left: []string{"p3"}, LastInputSeq: bigSeq + 2,
+		}}, serverFrame{Type: EventRoomDelta}},
+		{"move reply", Reply{RequestID: "7", OK: true, Data: player}, serverFrame{RequestID: "7", OK: true}},
+		{"attack reply", Reply{RequestID: "8", OK: true, Data: AttackResult{Attacker: player, Target: target, RewindMs: 80}},
+			serverFrame{RequestID: "8", OK: true}},
+		{"other event", Event{Type: EventMatchFound, Seq: bigSeq, Data: map[string]interface{}{"roomId": "r9"}},
+			serverFrame{Type: EventMatchFound, Seq: bigSeq}},
+	}
+
+	for _, codec := range allCodecs {
+		for _, tt := range tests {
+			frame, err := codec.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("%s %s: %v", codec.Name(), tt.name, err)
+			}
+
+			var wantData interface{}
+			switch msg := tt.msg.(type) {
+			case Event:
+				wantData = msg.Data
+			case Reply:
+				wantData = msg.Data
+			}
+			data := reflect.New(reflect.TypeOf(wantData))
+
+			got := decodeServerFrame(t, codec, frame, data.Interface())
+			got.Data = nil
+			if got != tt.want {
+				t.Errorf("%s %s: frame = %+v, want %+v", codec.Name(), tt.name, got, tt.want)
+			}
+			if !reflect.DeepEqual(data.Elem().Interface(), wantData) {
+				t.Errorf("%s %s: data = %+v, want %+v", codec.Name(), tt.name, data.Elem().Interface(), wantData)
+			}
+		}
+	}
+}
+
+func TestCodecSwitchAppliesToTheNextClientFrame(t *testing.T) {
+	c := pipeClient(t, testServer(t, LoadConfig()), FrameModeLengthPrefixed)
+
+	// Each client sends its next frame in the negotiated codec without
+	// waiting for the reply, which comes in the codec it was sent in.
+	c.send("hello", "1", ClientHello{ProtocolVersion: ProtocolVersion, Capabilities: ClientCapabilities{Codecs: []string{CodecMsgpack}}})
+	c.codec = MsgpackCodec
+	c.send("set_codec", "2", TCPSetCodec{Codec: CodecProtobuf})
+	c.codec = ProtobufCodec
+	c.send("ping", "3", nil)
+
+	steps := []struct {
+		codec Codec
+		data  interface{}
+	}{
+		{JSONCodec, &ServerHello{}},
+		{MsgpackCodec, &map[string]interface{}{}},
+		{ProtobufCodec, &map[string]interface{}{}},
+	}
+	for i, step := range steps {
+		c.codec = step.codec
+		if r := c.next(step.data); !r.OK || r.RequestID != fmt.Sprint(i+1) {
+			t.Fatalf("reply %d in %s: %+v", i+1, step.codec.Name(), r)
+		}
+	}
+}
+
+func TestMsgpackMatchesTheJSONSchema(t *testing.T) {
+	attachment := "https://cdn.example.com/a.png"
+	msg := model.Message{
+		ID:             primitive.NewObjectID(),
+		ConversationID: primitive.NewObjectID(),
+		SenderID:       primitive.NewObjectID(),
+		Content:        "hi",
+		AttachmentURL:  &attachment,
+		CreatedAt:      time.Date(2026, 3, 1, 12, 30, 0, 123456789, time.UTC),
+	}
+	ev := Event{Type: EventMessageNew, Seq: 3, Data: msg}
+
+	// Both decode to the same generic shape: IDs as hex strings and times
+	// as RFC 3339.
+	shapes := map[string]interface{}{}
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec} {
+		frame, err := codec.Marshal(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var shape map[string]interface{}
+		must(t, codec.Unmarshal(frame, &shape))
+		shapes[codec.Name()] = shape
+
+		var got struct {
+			Data model.Message `json:"data"`
+		}
+		must(t, codec.Unmarshal(frame, &got))
+		if !reflect.DeepEqual(got.Data, msg) {
+			t.Errorf("%s: message = %+v, want %+v", codec.Name(), got.Data, msg)
+		}
+	}
+
+	want, _ := json.Marshal(shapes[CodecJSON])
+	got, _ := json.Marshal(shapes[CodecMsgpack])
+	if string(got) != string(want) {
+		t.Errorf("msgpack shape %s\nwant the JSON one %s", got, want)
+	}
+}
+
+// protocolFile compiles protocol.proto, the schema clients generate their
+// code from.
+func protocolFile(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	compiler := protocompile.Compiler{Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{})}
+	files, err := compiler.Compile(context.Background(), "protocol.proto")
+	must(t, err)
+	return files[0]
+}
+
+// fromJSON builds message name from the JSON encoding of v, which has the
+// field names of protocol.proto.
+func fromJSON(t *testing.T, file protoreflect.FileDescriptor, name string, v interface{}) *dynamicpb.Message {
+	t.Helper()
+	data, err := JSONCodec.Marshal(v)
+	must(t, err)
+	msg := dynamicpb.NewMessage(file.Messages().ByName(protoreflect.Name(name)))
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		t.Fatalf("%s from %s: %v", name, data, err)
+	}
+	return msg
+}
+
+func field(m protoreflect.Message, name string) protoreflect.Value {
+	return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+}
+
+// TestProtobufMatchesProtocolProto checks the hand-written field numbers
+// against protocol.proto, in both directions.
+func TestProtobufMatchesProtocolProto(t *testing.T) {
+	file := protocolFile(t)
+
+	commands := []struct {
+		field, message string
+		cmd            interface{}
+	}{
+		{"move", "Move", TCPMove{RoomID: "r1", Seq: bigSeq, X: 1.5, Y: -2}},
+		{"attack", "Attack", TCPAttack{RoomID: "r1", Seq: bigSeq + 1, TargetID: "p2", ViewTime: 1700000000123}},
+		{"ack_state", "AckState", TCPAckState{RoomID: "r1", Tick: bigSeq + 2}},
+		{"payload", "", TCPReady{RoomID: "r1", Ready: true}},
+	}
+	for _, tt := range commands {
+		env := dynamicpb.NewMessage(file.Messages().ByName("Envelope"))
+		env.Set(env.Descriptor().Fields().ByName("type"), protoreflect.ValueOfString(tt.field))
+		env.Set(env.Descriptor().Fields().ByName("request_id"), protoreflect.ValueOfString("1"))
+		if tt.message != "" {
+			env.Set(env.Descriptor().Fields().ByName(protoreflect.Name(tt.field)), protoreflect.ValueOfMessage(fromJSON(t, file, tt.message, tt.cmd)))
+		} else {
+			value, err := structpb.NewValue(map[string]interface{}{"roomId": "r1", "ready": true})
+			must(t, err)
+			env.Set(env.Descriptor().Fields().ByName("payload"), protoreflect.ValueOfMessage(value.ProtoReflect()))
+		}
+		frame, err := proto.Marshal(env)
+		must(t, err)
+
+		decoded, err := ProtobufCodec.DecodeEnvelope(frame)
+		must(t, err)
+		got := reflect.New(reflect.TypeOf(tt.cmd))
+		must(t, decoded.Bind(got.Interface()))
+		if decoded.Type != tt.field || decoded.RequestID != "1" || !reflect.DeepEqual(got.Elem().Interface(), tt.cmd) {
+			t.Errorf("%s: decoded %q %q %+v, want %+v", tt.field, decoded.Type, decoded.RequestID, got.Elem().Interface(), tt.cmd)
+		}
+	}
+
+	x, health := 0.0, 0
+	player := game.PlayerState{ID: "p1", Name: "Ann", X: 1.5, Y: -2, Health: 100, Ready: true, Kills: 2, Deaths: 1}
+	target := game.PlayerState{ID: "p2", Name: "Bo", Health: -5}
+	snapshot := game.RoomSnapshot{RoomID: "r1", Tick: bigSeq, ServerTime: 1700000000123, State: game.RoomActive,
+		Players: []game.PlayerState{player, target}, LastInputSeq: bigSeq + 1}
+	delta := game.RoomDelta{RoomID: "r1", Tick: bigSeq + 3, BaseTick: bigSeq, ServerTime: 1700000000123, State: game.RoomFinished,
+		Changed: []game.PlayerDelta{{ID: "p1", X: &x, Health: &health}}, Joined: []game.PlayerState{target},
+		Left: []string{"p3"}, LastInputSeq: bigSeq + 2}
+	result := AttackResult{Attacker: player, Target: target, RewindMs: 80}
+
+	messages := []struct {
+		msg                   interface{}
+		body, field, dataType string
+		data                  interface{}
+	}{
+		{Event{Type: EventRoomState, Seq: 4, Data: snapshot}, "event", "room_state", "RoomSnapshot", snapshot},
+		{Event{Type: EventRoomDelta, Seq: 5, Data: delta}, "event", "room_delta", "RoomDelta", delta},
+		{Reply{RequestID: "7", OK: true, Data: player}, "reply", "player", "PlayerState", player},
+		{Reply{RequestID: "8", OK: true, Data: result}, "reply", "attack", "AttackResult", result},
+	}
+	for _, tt := range messages {
+		frame, err := ProtobufCodec.Marshal(tt.msg)
+		must(t, err)
+		server := dynamicpb.NewMessage(file.Messages().ByName("ServerMessage"))
+		must(t, proto.Unmarshal(frame, server))
+
+		body := field(server, tt.body).Message()
+		if len(server.GetUnknown()) > 0 || len(body.GetUnknown()) > 0 {
+			t.Errorf("%s: fields missing from protocol.proto: %s", tt.field, protojson.Format(server))
+		}
+		switch msg := tt.msg.(type) {
+		case Event:
+			if field(body, "type").String() != msg.Type || field(body, "seq").Uint() != msg.Seq {
+				t.Errorf("%s: event header %s", tt.field, protojson.Format(body.Interface()))
+			}
+		case Reply:
+			if field(body, "request_id").String() != msg.RequestID || !field(body, "ok").Bool() {
+				t.Errorf("%s: reply header %s", tt.field, protojson.Format(body.Interface()))
+			}
+		}
+
+		got := field(body, tt.field).Message().Interface()
+		want := fromJSON(t, file, tt.dataType, tt.data)
+		if !proto.Equal(got, want) {
+			t.Errorf("%s:\n got %s\nwant %s", tt.field, protojson.Format(got), protojson.Format(want))
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package tcp
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the tunables of the TCP server. Values are read from the
+// environment, falling back to the defaults below.
+type Config struct {
+	// MinProtocolVersion is the oldest protocol revision accepted in hello.
+	// Older clients get an upgrade_required error and are disconnected.
+	MinProtocolVersion int
+
+	// AuthTimeout is how long a new connection has to send a valid auth
+	// command before it is disconnected.
+	AuthTimeout time.Duration
+
+	// IdleTimeout closes authenticated connections that send nothing,
+	// not even a pong, for this long.
+	IdleTimeout time.Duration
+
+	// PingInterval is how often the server pings each client. It should be
+	// well below IdleTimeout.
+	PingInterval time.Duration
+
+	// WriteTimeout bounds every write to a client.
+	WriteTimeout time.Duration
+
+	// OutboundQueueSize bounds each lane of a session's outbound queue.
+	OutboundQueueSize int
+
+	// OverflowPolicy is OverflowDropOldest or OverflowDisconnect.
+	OverflowPolicy string
+
+	// ResumeGrace is how long a dropped session keeps its room seats and
+	// keeps recording events for a client that reconnects with "resume".
+	ResumeGrace time.Duration
+
+	// ResumeOutboxSize is how many recent events are kept per session for
+	// replay after a resume.
+	ResumeOutboxSize int
+
+	// MaxFrameSize is the largest inbound frame, in bytes, in either framing
+	// mode. Larger frames close the connection with a protocol error.
+	MaxFrameSize int
+
+	// Addr is the TCP listen address. Connections pick their framing with
+	// the handshake byte.
+	Addr string
+
+	// BinaryAddr, when set, is an extra listen address whose connections
+	// always use length-prefixed framing without the handshake byte.
+	BinaryAddr string
+
+	// BotAddr, when set, is an extra listen address for trusted internal
+	// bots. It needs TLS and rejects clients without a certificate signed
+	// by TLSClientCAFile; the certificate's CommonName is the bot's user ID
+	// and lets it authenticate without a token.
+	BotAddr string
+
+	// TLSCertFile and TLSKeyFile enable TLS on the TCP listeners. Replaced
+	// files are picked up without a restart, see TLSReloadInterval.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSClientCAFile holds the CAs that sign client certificates, used by
+	// trusted internal bots.
+	TLSClientCAFile string
+
+	// TLSClientAuth is ClientAuthNone or ClientAuthOptional, for Addr and
+	// BinaryAddr.
+	TLSClientAuth string
+
+	// TLSReloadInterval is how often, at most, a handshake checks the
+	// certificate files for changes.
+	TLSReloadInterval time.Duration
+
+	// SessionRateLimits and UserRateLimits limit each command type per
+	// connection and per user across all of the user's connections.
+	SessionRateLimits RateLimits
+	UserRateLimits    RateLimits
+
+	// RateLimitMaxViolations is how many throttled commands a session may
+	// send within RateLimitViolationWindow before it is disconnected.
+	RateLimitMaxViolations   int
+	RateLimitViolationWindow time.Duration
+
+	// ShutdownReconnectHint is how long clients are told to wait before
+	// reconnecting when the server shuts down.
+	ShutdownReconnectHint time.Duration
+
+	// WSAllowedOrigins lists the browser origins allowed to open the
+	// WebSocket gateway; "*" allows any origin.
+	WSAllowedOrigins []string
+}
+
+// Default command limits, in commands per second and burst. Commands that
+// write to Mongo are limited the most.
+var (
+	defaultSessionRateLimits = RateLimits{
+		RateLimitDefault:            {Rate: 20, Burst: 40},
+		"send_message":              {Rate: 5, Burst: 10},
+		"create_conversation":       {Rate: 1, Burst: 5},
+		"create_group_conversation": {Rate: 0.2, Burst: 3},
+		"create_room":               {Rate: 1, Burst: 5},
+		"queue_join":                {Rate: 1, Burst: 3},
+		"party_invite":              {Rate: 1, Burst: 5},
+		// Game traffic follows the tick rate.
+		"move":      {Rate: 60, Burst: 120},
+		"attack":    {Rate: 10, Burst: 20},
+		"ack_state": {Rate: 60, Burst: 120},
+		"ready":     {Rate: 2, Burst: 5},
+	}
+	defaultUserRateLimits = RateLimits{
+		"send_message":              {Rate: 10, Burst: 20},
+		"create_conversation":       {Rate: 2, Burst: 10},
+		"create_group_conversation": {Rate: 0.5, Burst: 5},
+		"create_room":               {Rate: 2, Burst: 10},
+	}
+)
+
+func LoadConfig() Config {
+	return Config{
+		MinProtocolVersion: envInt("TCP_MIN_PROTOCOL_VERSION", 1),
+
+		AuthTimeout:  envDuration("TCP_AUTH_TIMEOUT", 10*time.Second),
+		IdleTimeout:  envDuration("TCP_IDLE_TIMEOUT", 60*time.Second),
+		PingInterval: envDuration("TCP_PING_INTERVAL", 20*time.Second),
+		WriteTimeout: envDuration("TCP_WRITE_TIMEOUT", 10*time.Second),
+		MaxFrameSize: envInt("TCP_MAX_FRAME_SIZE", 64*1024),
+
+		OutboundQueueSize: envInt("TCP_OUTBOUND_QUEUE_SIZE", 256),
+		OverflowPolicy:    envOverflowPolicy("TCP_OVERFLOW_POLICY"),
+
+		ResumeGrace:      envDuration("TCP_RESUME_GRACE", 30*time.Second),
+		ResumeOutboxSize: envInt("TCP_RESUME_OUTBOX_SIZE", 256),
+
+		Addr:       envString("TCP_ADDR", ":9090"),
+		BinaryAddr: os.Getenv("TCP_BINARY_ADDR"),
+		BotAddr:    os.Getenv("TCP_BOT_ADDR"),
+
+		TLSCertFile:       os.Getenv("TCP_TLS_CERT_FILE"),
+		TLSKeyFile:        os.Getenv("TCP_TLS_KEY_FILE"),
+		TLSClientCAFile:   os.Getenv("TCP_TLS_CLIENT_CA_FILE"),
+		TLSClientAuth:     envString("TCP_TLS_CLIENT_AUTH", ClientAuthNone),
+		TLSReloadInterval: envDuration("TCP_TLS_RELOAD_INTERVAL", 30*time.Second),
+
+		SessionRateLimits:        envRateLimits("TCP_SESSION_RATE_LIMITS", defaultSessionRateLimits),
+		UserRateLimits:           envRateLimits("TCP_USER_RATE_LIMITS", defaultUserRateLimits),
+		RateLimitMaxViolations:   envInt("TCP_RATE_LIMIT_MAX_VIOLATIONS", 20),
+		RateLimitViolationWindow: envDuration("TCP_RATE_LIMIT_VIOLATION_WINDOW", time.Minute),
+
+		ShutdownReconnectHint: envDuration("TCP_SHUTDOWN_RECONNECT_HINT", 5*time.Second),
+
+		WSAllowedOrigins: envList("WS_ALLOWED_ORIGINS", []string{"http://localhost:5173"}),
+	}
+}
+
+// TLSEnabled reports whether the TCP listeners serve TLS.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSKeyFile != ""
+}
+
+func envString(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
+func envList(key string, fallback []string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return fallback
+	}
+	return list
+}
+
+// envRateLimits overrides defaults with the entries in key, see
+// parseRateLimits.
+func envRateLimits(key string, defaults RateLimits) RateLimits {
+	limits, err := parseRateLimits(os.Getenv(key), defaults)
+	if err != nil {
+		fmt.Printf("Ignoring %s: %v\n", key, err)
+	}
+	return limits
+}
+
+func envOverflowPolicy(key string) string {
+	if os.Getenv(key) == OverflowDisconnect {
+		return OverflowDisconnect
+	}
+	return OverflowDropOldest
+}
@@ -0,0 +1,211 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Heartbeat frames handled by the connection itself rather than the router.
+// The server sends {"type":"ping"} every PingInterval and clients answer
+// with {"type":"pong"}; clients may also send the "ping" command.
+const (
+	EventPing = "ping"
+	framePong = "pong"
+)
+
+// HandleConnection serves a client whose framing is chosen by the
+// handshake byte.
+func (srv *Server) HandleConnection(conn net.Conn) {
+	srv.handleConnection(conn, nil)
+}
+
+func (srv *Server) handleConnection(conn net.Conn, forcedMode *FrameMode) {
+	// Unauthenticated connections only get AuthTimeout to send "auth".
+	conn.SetReadDeadline(time.Now().Add(srv.cfg.AuthTimeout))
+
+	// The TLS handshake counts against AuthTimeout too.
+	var clientName string
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			fmt.Println("TLS handshake failed:", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		clientName = verifiedClientName(tlsConn)
+	}
+
+	reader := bufio.NewReader(conn)
+	framer, err := negotiateFramer(reader, conn, srv.cfg.MaxFrameSize, forcedMode)
+	if err != nil {
+		fmt.Println("Framing handshake failed:", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	session := newSession(conn, framer, srv.cfg)
+	session.clientName = clientName
+	srv.serveSession(session)
+}
+
+// serveSession runs the read loop of a TCP or WebSocket session until the
+// peer disconnects, times out or breaks the protocol.
+func (srv *Server) serveSession(session *Session) {
+	if !srv.trackSession(session) {
+		session.abort()
+		return
+	}
+	defer func() {
+		srv.closeSession(session)
+		<-session.flushed
+		srv.untrackSession(session)
+	}()
+
+	conn := session.Conn
+	framer := session.framer
+
+	go srv.pingLoop(session)
+
+	for {
+		msg, err := framer.ReadFrame()
+		if err != nil {
+			if session.closed() {
+				fmt.Println("Session closed:", conn.RemoteAddr())
+			} else if errors.Is(err, ErrFrameTooLarge) {
+				session.Send(errorReply("", NewCommandError(CodeProtocolError, err.Error())))
+				fmt.Println("Protocol error:", conn.RemoteAddr(), err)
+			} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if !session.Authenticated() {
+					session.Send(errorReply("", NewCommandError(CodeUnauthorized, "authentication timeout")))
+				}
+				fmt.Println("Client timed out:", conn.RemoteAddr())
+			} else {
+				fmt.Println("Client disconnected:", conn.RemoteAddr())
+			}
+			break
+		}
+
+		// Any traffic from an authenticated peer proves it is alive.
+		if session.Authenticated() {
+			conn.SetReadDeadline(time.Now().Add(srv.cfg.IdleTimeout))
+		}
+
+		if len(msg) == 0 {
+			continue
+		}
+
+		var reply Reply
+		env, err := session.inCodec.DecodeEnvelope(msg)
+		if err != nil {
+			reply = errorReply("", NewCommandError(CodeBadRequest, "invalid envelope: "+err.Error()))
+		} else if env.Type == framePong {
+			continue
+		} else {
+			if cmdErr, disconnect := srv.limiter.allow(session, env.Type); cmdErr != nil {
+				reply = errorReply(env.RequestID, cmdErr)
+				if disconnect {
+					fmt.Println("Rate limit exceeded, disconnecting:", conn.RemoteAddr())
+					// A resumed session would start over with full
+					// buckets, so its token goes right away.
+					srv.resumes.take(session.ResumeToken())
+					session.throttled = true
+					session.closeAfter()
+				}
+			} else if !srv.beginCommand() {
+				reply = errorReply(env.RequestID, srv.shutdownError())
+			} else {
+				wasAuthenticated := session.Authenticated()
+				var later Deferred
+				reply, later = srv.router.Dispatch(session, env)
+				session.applyCodecSwitch()
+				if later != nil {
+					srv.replyLater(session, env.RequestID, later)
+					continue
+				}
+				srv.inflight.Done()
+				if !wasAuthenticated && session.Authenticated() {
+					conn.SetReadDeadline(time.Now().Add(srv.cfg.IdleTimeout))
+				}
+			}
+		}
+
+		if err := session.sendReply(reply); err != nil {
+			fmt.Println("Send error:", conn.RemoteAddr(), err)
+			break
+		}
+	}
+}
+
+// replyLater starts a deferred command. It stays in flight until its reply
+// is queued, which then goes out like an event, after whatever the session
+// was sent meanwhile.
+func (srv *Server) replyLater(s *Session, requestID string, later Deferred) {
+	var once sync.Once
+	later(func(data interface{}, err error) {
+		once.Do(func() {
+			reply := okReply(requestID, data)
+			if err != nil {
+				reply = errorReply(requestID, err)
+			}
+			s.Send(reply)
+			srv.inflight.Done()
+		})
+	})
+}
+
+// pingLoop keeps NAT mappings alive and lets the read deadline detect
+// half-open connections whose peer stopped answering.
+func (srv *Server) pingLoop(s *Session) {
+	ticker := time.NewTicker(srv.cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Done():
+			return
+		case <-ticker.C:
+			ping := Event{Type: EventPing, Data: map[string]int64{"serverTime": time.Now().UnixMilli()}}
+			if err := s.sendTransient(ping); err != nil {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// closeSession runs when a connection ends. Authenticated sessions are
+// parked for ResumeGrace so a reconnecting client can take them over,
+// unless the server is shutting down or the rate limiter disconnected
+// them; sessions that were resumed elsewhere have nothing left to release.
+func (srv *Server) closeSession(s *Session) {
+	s.Close()
+
+	if s.superseded() {
+		return
+	}
+	if s.Authenticated() && !s.throttled && !srv.isShuttingDown() {
+		srv.resumes.park(s, srv.cfg.ResumeGrace)
+		return
+	}
+	srv.releaseSession(s)
+}
+
+// releaseSession drops everything a session holds: its resume token, its
+// hub registration, its seats in game rooms and, with the user's last
+// session, their party and their place in the matchmaking queue.
+func (srv *Server) releaseSession(s *Session) {
+	srv.resumes.take(s.ResumeToken())
+	srv.hub.Unregister(s)
+	if userID := s.UserID(); userID != "" && !srv.hub.Online(userID) {
+		srv.parties.quit(userID, nil)
+		srv.matches.Leave(userID)
+	}
+
+	for roomID, p := range s.roomSeats() {
+		srv.deps.Rooms.DisconnectPlayer(roomID, p)
+	}
+}
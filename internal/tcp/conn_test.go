@@ -0,0 +1,175 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/utils"
+)
+
+// testClient speaks the protocol to a server over net.Pipe. Frames are
+// encoded and decoded with codec.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	framer Framer
+	codec  Codec
+}
+
+// pipeClient connects a client to srv with the given framing, as if it had
+// been accepted by a listener.
+func pipeClient(t *testing.T, srv *Server, mode FrameMode) *testClient {
+	t.Helper()
+
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go srv.handleConnection(server, &mode)
+
+	reader := bufio.NewReader(client)
+	var framer Framer = &lineFramer{r: reader, w: client, maxSize: srv.cfg.MaxFrameSize}
+	if mode == FrameModeLengthPrefixed {
+		framer = &lengthFramer{r: reader, w: client, maxSize: srv.cfg.MaxFrameSize}
+	}
+	return &testClient{t: t, conn: client, framer: framer, codec: JSONCodec}
+}
+
+func (c *testClient) send(cmdType, requestID string, payload interface{}) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if err := c.framer.WriteFrame(encodeCommand(c.t, c.codec, cmdType, requestID, payload)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next reads the next frame, with its data into the pointer data.
+func (c *testClient) next(data interface{}) serverFrame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := c.framer.ReadFrame()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return decodeServerFrame(c.t, c.codec, frame, data)
+}
+
+// call sends a command and returns its reply, skipping the events sent
+// before it.
+func (c *testClient) call(cmdType, requestID string, payload, data interface{}) serverFrame {
+	c.t.Helper()
+	c.send(cmdType, requestID, payload)
+	for {
+		if f := c.next(data); f.Type == "" {
+			return f
+		}
+	}
+}
+
+// hello completes the hello exchange without changing codec.
+func (c *testClient) hello() {
+	c.t.Helper()
+	if r := c.call("hello", "hello", ClientHello{ProtocolVersion: ProtocolVersion}, &ServerHello{}); !r.OK {
+		c.t.Fatalf("hello failed: %+v", r.Error)
+	}
+}
+
+// auth authenticates the session as userID with a login JWT.
+func (c *testClient) auth(userID string) {
+	c.t.Helper()
+	token, err := utils.GenerateJWT(userID)
+	must(c.t, err)
+	if r := c.call("auth", "auth", TCPAuth{Token: token}, nil); !r.OK {
+		c.t.Fatalf("auth failed: %+v", r.Error)
+	}
+}
+
+// event reads up to the next eventType event, with its data into the
+// pointer data. It only speaks JSON.
+func (c *testClient) event(eventType string, data interface{}) serverFrame {
+	c.t.Helper()
+	for {
+		var raw json.RawMessage
+		if f := c.next(&raw); f.Type == eventType {
+			must(c.t, json.Unmarshal(raw, data))
+			f.Data = data
+			return f
+		}
+	}
+}
+
+// closed reports whether the server closed the connection, reading and
+// discarding anything it sent before.
+func (c *testClient) closed(within time.Duration) bool {
+	c.conn.SetReadDeadline(time.Now().Add(within))
+	for {
+		if _, err := c.framer.ReadFrame(); err != nil {
+			ne, ok := err.(net.Error)
+			return !ok || !ne.Timeout()
+		}
+	}
+}
+
+func testServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	srv := NewServer(Dependencies{}, cfg)
+	t.Cleanup(func() { srv.deps.Rooms.Close() })
+	return srv
+}
+
+func TestClientPingNeedsNoHello(t *testing.T) {
+	c := pipeClient(t, testServer(t, LoadConfig()), FrameModeLine)
+
+	var pong map[string]int64
+	if r := c.call("ping", "1", nil, &pong); !r.OK || pong["serverTime"] == 0 {
+		t.Fatalf("ping before hello = %+v, %v", r.Error, pong)
+	}
+	if r := c.call("auth", "2", TCPAuth{Token: "x"}, nil); r.Error == nil || r.Error.Code != CodeHelloRequired {
+		t.Errorf("auth before hello = %+v, want %s", r.Error, CodeHelloRequired)
+	}
+	c.hello()
+	if r := c.call("ping", "3", nil, &pong); !r.OK {
+		t.Errorf("ping after hello = %+v", r.Error)
+	}
+}
+
+func TestServerPingsAndIdleTimeout(t *testing.T) {
+	cfg := LoadConfig()
+	cfg.PingInterval = 20 * time.Millisecond
+	cfg.IdleTimeout = 200 * time.Millisecond
+	c := pipeClient(t, testServer(t, cfg), FrameModeLine)
+	c.hello()
+	c.auth(primitive.NewObjectID().Hex())
+
+	// Pongs keep the connection open past IdleTimeout.
+	for deadline := time.Now().Add(2 * cfg.IdleTimeout); time.Now().Before(deadline); {
+		if f := c.next(nil); f.Type != EventPing {
+			t.Fatalf("frame = %+v, want a ping", f)
+		}
+		c.send(framePong, "", nil)
+	}
+
+	if !c.closed(2 * time.Second) {
+		t.Error("idle connection still open")
+	}
+}
+
+func TestWriteDeadlineDropsStalledClients(t *testing.T) {
+	cfg := LoadConfig()
+	cfg.PingInterval = 10 * time.Millisecond
+	cfg.WriteTimeout = 50 * time.Millisecond
+	srv := testServer(t, cfg)
+	c := pipeClient(t, srv, FrameModeLine)
+	c.hello()
+
+	// The client stops reading; the server's pings block on the pipe
+	// until the write deadline drops the session.
+	for deadline := time.Now().Add(2 * time.Second); len(srv.liveSessions()) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("stalled client still connected")
+		}
+	}
+}
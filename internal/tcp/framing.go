@@ -0,0 +1,137 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// FrameMode selects how messages are delimited on a connection.
+type FrameMode byte
+
+const (
+	// FrameModeLine is newline-delimited, the original protocol.
+	FrameModeLine FrameMode = iota
+	// FrameModeLengthPrefixed prefixes every frame with its length as a
+	// 4-byte big-endian unsigned integer.
+	FrameModeLengthPrefixed
+	// FrameModeWebSocket maps one frame to one WebSocket message.
+	FrameModeWebSocket
+)
+
+// FrameHandshakeLengthPrefixed is sent by a client as the very first byte of
+// a connection to switch it to length-prefixed framing. Line-mode clients
+// start with JSON and never send it.
+const FrameHandshakeLengthPrefixed byte = 0x01
+
+const lengthPrefixSize = 4
+
+// ErrFrameTooLarge is returned when a peer announces or sends a frame above
+// the configured maximum. The connection must be closed afterwards since the
+// stream can no longer be resynchronised.
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+
+// Framer reads and writes whole protocol frames. WriteFrame is not safe for
+// concurrent use; Session serialises writes.
+type Framer interface {
+	ReadFrame() ([]byte, error)
+	WriteFrame(payload []byte) error
+	Mode() FrameMode
+}
+
+// negotiateFramer picks the framing of a new connection. Connections
+// accepted on a dedicated binary listener are always length-prefixed;
+// otherwise the first byte decides without consuming any line-mode data.
+func negotiateFramer(r *bufio.Reader, w io.Writer, maxSize int, forced *FrameMode) (Framer, error) {
+	mode := FrameModeLine
+	if forced != nil {
+		mode = *forced
+	} else {
+		first, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if first[0] == FrameHandshakeLengthPrefixed {
+			r.Discard(1)
+			mode = FrameModeLengthPrefixed
+		}
+	}
+
+	if mode == FrameModeLengthPrefixed {
+		return &lengthFramer{r: r, w: w, maxSize: maxSize}, nil
+	}
+	return &lineFramer{r: r, w: w, maxSize: maxSize}, nil
+}
+
+// lineFramer implements newline-delimited frames with an upper bound on the
+// line length. Payloads must not contain a newline; encoding/json never
+// emits one.
+type lineFramer struct {
+	r       *bufio.Reader
+	w       io.Writer
+	maxSize int
+}
+
+func (f *lineFramer) Mode() FrameMode { return FrameModeLine }
+
+func (f *lineFramer) ReadFrame() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := f.r.ReadSlice('\n')
+		if len(line)+len(chunk) > f.maxSize+1 {
+			return nil, ErrFrameTooLarge
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(line), nil
+	}
+}
+
+func (f *lineFramer) WriteFrame(payload []byte) error {
+	_, err := f.w.Write(append(payload, '\n'))
+	return err
+}
+
+// lengthFramer implements 4-byte big-endian length-prefixed frames.
+type lengthFramer struct {
+	r       *bufio.Reader
+	w       io.Writer
+	maxSize int
+}
+
+func (f *lengthFramer) Mode() FrameMode { return FrameModeLengthPrefixed }
+
+func (f *lengthFramer) ReadFrame() ([]byte, error) {
+	var prefix [lengthPrefixSize]byte
+	if _, err := io.ReadFull(f.r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(prefix[:])
+	if uint64(size) > uint64(f.maxSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(f.r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (f *lengthFramer) WriteFrame(payload []byte) error {
+	frame := make([]byte, lengthPrefixSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[lengthPrefixSize:], payload)
+
+	_, err := f.w.Write(frame)
+	return err
+}
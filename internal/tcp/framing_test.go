@@ -0,0 +1,88 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFramer(t *testing.T) {
+	var frame bytes.Buffer
+	(&lengthFramer{w: &frame}).WriteFrame([]byte(`{"type":"ping"}`))
+
+	// The handshake byte switches to length-prefixed framing and is not
+	// part of the first frame.
+	r := bufio.NewReader(bytes.NewReader(append([]byte{FrameHandshakeLengthPrefixed}, frame.Bytes()...)))
+	f, err := negotiateFramer(r, nil, 64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.ReadFrame(); f.Mode() != FrameModeLengthPrefixed || err != nil || string(got) != `{"type":"ping"}` {
+		t.Errorf("handshake: mode %v, frame %q, err %v", f.Mode(), got, err)
+	}
+
+	// Without it, the first byte belongs to the first line.
+	r = bufio.NewReader(strings.NewReader(`{"type":"ping"}` + "\n"))
+	f, err = negotiateFramer(r, nil, 64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.ReadFrame(); f.Mode() != FrameModeLine || err != nil || string(got) != `{"type":"ping"}` {
+		t.Errorf("line: mode %v, frame %q, err %v", f.Mode(), got, err)
+	}
+
+	// A forced mode needs no handshake byte.
+	mode := FrameModeLengthPrefixed
+	f, err = negotiateFramer(bufio.NewReader(bytes.NewReader(frame.Bytes())), nil, 64, &mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.ReadFrame(); err != nil || string(got) != `{"type":"ping"}` {
+		t.Errorf("forced: frame %q, err %v", got, err)
+	}
+}
+
+func TestLineFramerMaxSize(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	tests := []struct {
+		input string
+		want  string
+		err   error
+	}{
+		{"12345678\n", "12345678", nil},
+		{"123456789\n", "", ErrFrameTooLarge},
+		{" 123456\r\n", "123456", nil},
+		// Lines longer than the read buffer are joined across chunks.
+		{long + "\n", long, nil},
+		{long + "y\n", "", ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		maxSize := 8
+		if strings.Contains(tt.input, long) {
+			maxSize = len(long)
+		}
+		f := &lineFramer{r: bufio.NewReaderSize(strings.NewReader(tt.input), 16), maxSize: maxSize}
+
+		got, err := f.ReadFrame()
+		if !errors.Is(err, tt.err) || string(got) != tt.want {
+			t.Errorf("%q: got %q, %v; want %q, %v", tt.input, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestLengthFramerMaxSize(t *testing.T) {
+	var buf bytes.Buffer
+	w := &lengthFramer{w: &buf}
+	w.WriteFrame([]byte("12345678"))
+	w.WriteFrame([]byte("123456789"))
+
+	f := &lengthFramer{r: bufio.NewReader(&buf), maxSize: 8}
+	if got, err := f.ReadFrame(); err != nil || string(got) != "12345678" {
+		t.Fatalf("frame at the limit: %q, %v", got, err)
+	}
+	if _, err := f.ReadFrame(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("oversized frame: %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -1,198 +1,317 @@
 package tcp
 
 import (
-	"bufio"
-	"encoding/json"
-	"fmt"
-	"net"
 	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"game_tcpserver/internal/game"
 	"game_tcpserver/internal/model"
 	"game_tcpserver/internal/service"
+	"game_tcpserver/internal/utils"
 )
 
 type Dependencies struct {
 	ConversationService *service.ConversationService
 	MessageService      *service.MessageService
-	Conn                net.Conn // Add net.Conn to Dependencies
+	// Rooms holds the game rooms; NewServer creates one with
+	// game.DefaultRules when it is nil.
+	Rooms *game.RoomManager
+	// Ratings rates players for matchmaking; nil rates everyone
+	// game.DefaultRating.
+	Ratings game.RatingLookup
 }
 
-func HandleConnection(conn net.Conn, deps Dependencies) {
-	defer conn.Close()
+type commandHandlers struct {
+	deps       Dependencies
+	hub        *Hub
+	cfg        Config
+	resumes    *resumeRegistry
+	matchmaker *game.Matchmaker
+	parties    *parties
+}
+
+// register wires every supported command into r.
+func (h *commandHandlers) register(r *Router) {
+	r.HandleHandshake("hello", h.hello)
+	r.HandlePublic("auth", h.auth)
+	r.HandlePublic("resume", h.resume)
+	r.HandleHandshake("ping", h.ping)
+	r.HandlePublic("set_codec", h.setCodec)
+	r.Handle("create_conversation", h.createConversation)
+	r.Handle("create_group_conversation", h.createGroupConversation)
+	r.Handle("send_message", h.sendMessage)
+	r.Handle("create_room", h.createRoom)
+	r.Handle("move", h.move)
+	r.Handle("attack", h.attack)
+	r.Handle("leave_room", h.leaveRoom)
+	r.Handle("ready", h.ready)
+	r.Handle("ack_state", h.ackState)
+	r.Handle("queue_join", h.queueJoin)
+	r.Handle("queue_leave", h.queueLeave)
+	r.Handle("party_invite", h.partyInvite)
+	r.Handle("party_accept", h.partyAccept)
+	r.Handle("party_leave", h.partyLeave)
+}
 
-	reader := bufio.NewReader(conn)
-	for {
-		msg, err := reader.ReadString('\n')
+type TCPAuth struct {
+	Token string `json:"token"`
+}
+
+// auth binds the session to the user in the JWT issued by UserService.Login.
+// Bots with a verified client certificate may leave the token out; the
+// certificate's CommonName is then their user ID. Every later command
+// takes its identity from the session.
+func (h *commandHandlers) auth(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPAuth
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	var userID string
+	switch {
+	case cmd.Token != "":
+		claims, err := utils.ValidateJWT(cmd.Token)
 		if err != nil {
-			fmt.Println("Client disconnected:", conn.RemoteAddr())
-			break
+			return nil, NewCommandError(CodeUnauthorized, err.Error())
+		}
+		if _, err := primitive.ObjectIDFromHex(claims.UserID); err != nil {
+			return nil, NewCommandError(CodeUnauthorized, "Invalid user in token")
+		}
+		userID = claims.UserID
+	case s.ClientName() != "":
+		if _, err := primitive.ObjectIDFromHex(s.ClientName()); err != nil {
+			return nil, NewCommandError(CodeUnauthorized, "Invalid user in client certificate")
 		}
+		userID = s.ClientName()
+	default:
+		return nil, NewCommandError(CodeBadRequest, "Missing token")
+	}
 
-		msg = strings.TrimSpace(msg)
-		fmt.Printf("[%v] %s\n", conn.RemoteAddr(), msg)
+	if current := s.UserID(); current != "" && current != userID {
+		return nil, NewCommandError(CodeConflict, "Session is already authenticated as another user")
+	}
+
+	if current := s.UserID(); current == "" {
+		s.setUserID(userID)
+		if err := h.startResumable(s); err != nil {
+			s.setUserID("")
+			return nil, err
+		}
+		h.hub.Register(s)
+	}
+
+	return map[string]interface{}{
+		"userId":        userID,
+		"resumeToken":   s.ResumeToken(),
+		"resumeGraceMs": h.cfg.ResumeGrace.Milliseconds(),
+	}, nil
+}
 
-		// Route message to appropriate handler
-		// Pass the connection to the dependencies for use in game-related functions
-		deps.Conn = conn
-		response := handleCommand(msg, deps) // Assuming handleCommand doesn't need conn directly
-		response = handleSendMessage(msg, deps)
-		response = handleRoom(msg, deps)
+// ping lets clients measure round-trip time; the reply carries the server
+// clock in milliseconds. It is the same in every protocol version, so it
+// is also accepted before hello.
+func (h *commandHandlers) ping(s *Session, env Envelope) (interface{}, error) {
+	return map[string]int64{"serverTime": time.Now().UnixMilli()}, nil
+}
 
+type TCPSetCodec struct {
+	Codec string `json:"codec"`
+}
+
+// setCodec switches the session to another payload codec. The reply is
+// still encoded with the current codec; every later frame in both
+// directions uses the new one.
+func (h *commandHandlers) setCodec(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPSetCodec
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
 
-		conn.Write([]byte(response + "\n"))
+	codec, ok := CodecByName(cmd.Codec)
+	if !ok {
+		return nil, NewCommandError(CodeBadRequest, "Unsupported codec: "+cmd.Codec)
 	}
+
+	if codec.Binary() && s.framer.Mode() == FrameModeLine {
+		return nil, NewCommandError(CodeBadRequest, "Binary codecs require length-prefixed or WebSocket framing")
+	}
+
+	s.switchCodec(codec)
+
+	return map[string]string{"codec": codec.Name()}, nil
+}
+
+// sessionUserID returns the authenticated user of s as an ObjectID.
+func sessionUserID(s *Session) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(s.UserID())
+	if err != nil {
+		return primitive.NilObjectID, NewCommandError(CodeUnauthorized, "authenticate first")
+	}
+	return id, nil
 }
 
 type TCPCommand struct {
-	Type           string   `json:"type"`
-	SenderID       string   `json:"senderId,omitempty"`
 	ReceiverID     string   `json:"receiverId,omitempty"`
 	Title          string   `json:"title,omitempty"`
-	CreatorID      string   `json:"creatorId,omitempty"`
 	ParticipantIDs []string `json:"participantIds,omitempty"`
 }
 
-func handleCommand(msg string, deps Dependencies) string {
+func (h *commandHandlers) createConversation(s *Session, env Envelope) (interface{}, error) {
 	var cmd TCPCommand
-	if err := json.Unmarshal([]byte(msg), &cmd); err != nil {
-		return "Invalid JSON format"
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
 	}
 
-	switch cmd.Type {
-	case "create_conversation":
-		if cmd.SenderID == "" || cmd.ReceiverID == "" {
-			return "Missing senderId or receiverId"
-		}
-
-		sender, err1 := primitive.ObjectIDFromHex(cmd.SenderID)
-		receiver, err2 := primitive.ObjectIDFromHex(cmd.ReceiverID)
-		if err1 != nil || err2 != nil {
-			return "Invalid sender or receiver ObjectID"
-		}
+	sender, err := sessionUserID(s)
+	if err != nil {
+		return nil, err
+	}
 
-		convo, err := deps.ConversationService.CreatePrivateConversation(sender, receiver)
-		if err != nil {
-			return fmt.Sprintf("Error creating conversation: %v", err)
-		}
+	if cmd.ReceiverID == "" {
+		return nil, NewCommandError(CodeBadRequest, "Missing receiverId")
+	}
 
-		return fmt.Sprintf("Conversation created with ID: %s", convo.ID.Hex())
+	receiver, err := primitive.ObjectIDFromHex(cmd.ReceiverID)
+	if err != nil {
+		return nil, NewCommandError(CodeBadRequest, "Invalid receiver ObjectID")
+	}
 
-	case "create_group_conversation":
-		if cmd.Title == "" || cmd.CreatorID == "" || len(cmd.ParticipantIDs) == 0 {
-			return "Missing title, creatorId, or participantIds"
-		}
+	return h.deps.ConversationService.CreatePrivateConversation(sender, receiver)
+}
 
-		creatorID, err := primitive.ObjectIDFromHex(cmd.CreatorID)
-		if err != nil {
-			return "Invalid creatorId"
-		}
+func (h *commandHandlers) createGroupConversation(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPCommand
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
 
-		var participantIDs []primitive.ObjectID
-		for _, idStr := range cmd.ParticipantIDs {
-			objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(idStr))
-			if err != nil {
-				return "Invalid participant ID: " + idStr
-			}
-			participantIDs = append(participantIDs, objID)
-		}
+	creatorID, err := sessionUserID(s)
+	if err != nil {
+		return nil, err
+	}
 
-		input := service.PrivateConv{
-			CreatorID:    creatorID,
-			Title:        cmd.Title,
-			Participants: participantIDs,
-		}
+	if cmd.Title == "" || len(cmd.ParticipantIDs) == 0 {
+		return nil, NewCommandError(CodeBadRequest, "Missing title or participantIds")
+	}
 
-		convo, err := deps.ConversationService.CreateGroupConversation(input)
+	// The creator is always a participant of the group.
+	participantIDs := []primitive.ObjectID{creatorID}
+	for _, idStr := range cmd.ParticipantIDs {
+		objID, err := primitive.ObjectIDFromHex(strings.TrimSpace(idStr))
 		if err != nil {
-			return "Error creating group conversation: " + err.Error()
+			return nil, NewCommandError(CodeBadRequest, "Invalid participant ID: "+idStr)
 		}
+		if objID != creatorID {
+			participantIDs = append(participantIDs, objID)
+		}
+	}
 
-		return "Group conversation created with ID: " + convo.ID.Hex()
-
-	default:
-		return "Unknown command"
+	input := service.PrivateConv{
+		CreatorID:    creatorID,
+		Title:        cmd.Title,
+		Participants: participantIDs,
 	}
+
+	return h.deps.ConversationService.CreateGroupConversation(input)
 }
 
 type TCPMessage struct {
-	Type           string `json:"type"`
-	SenderID       string `json:"senderId,omitempty"`
 	ConversationID string `json:"conversationId,omitempty"`
 	Content        string `json:"content,omitempty"`
 }
 
-func handleSendMessage(msg string, deps Dependencies) string {
+func (h *commandHandlers) sendMessage(s *Session, env Envelope) (interface{}, error) {
 	var cmd TCPMessage
-	if err := json.Unmarshal([]byte(msg), &cmd); err != nil {
-		return "Invalid JSON format"
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
 	}
 
-	switch cmd.Type {
-	case "send_message":
-		if cmd.SenderID == "" || cmd.ConversationID == "" || cmd.Content == "" {
-			return "Missing senderId, conversationId, or content"
-		}
+	senderID, err := sessionUserID(s)
+	if err != nil {
+		return nil, err
+	}
 
-		senderID, err1 := primitive.ObjectIDFromHex(cmd.SenderID)
-		convID, err2 := primitive.ObjectIDFromHex(cmd.ConversationID)
-		if err1 != nil || err2 != nil {
-			return "Invalid ObjectID(s)"
-		}
+	if cmd.ConversationID == "" || cmd.Content == "" {
+		return nil, NewCommandError(CodeBadRequest, "Missing conversationId or content")
+	}
 
-		message := model.Message{
-			SenderID:       senderID,
-			ConversationID: convID,
-			Content:        cmd.Content,
-		}
+	convID, err := primitive.ObjectIDFromHex(cmd.ConversationID)
+	if err != nil {
+		return nil, NewCommandError(CodeBadRequest, "Invalid conversationId")
+	}
 
-		saved, err := deps.MessageService.CreateMessage(message)
-		if err != nil {
-			return "Error saving message: " + err.Error()
-		}
+	convo, err := h.deps.ConversationService.GetConversation(convID)
+	if err != nil {
+		return nil, err
+	}
 
-		return "Message saved with ID: " + saved.ID.Hex()
+	if !isParticipant(convo, senderID) {
+		return nil, NewCommandError(CodeForbidden, "Not a participant of this conversation")
+	}
 
-	default:
-		return "Unknown command"
+	message := model.Message{
+		SenderID:       senderID,
+		ConversationID: convID,
+		Content:        cmd.Content,
+	}
+
+	saved, err := h.deps.MessageService.CreateMessage(message)
+	if err != nil {
+		return nil, err
+	}
+
+	// Push to every connected participant, including the sender's other
+	// devices; the sending session gets the reply instead.
+	recipients := make([]string, 0, len(convo.Participants))
+	for _, id := range convo.Participants {
+		recipients = append(recipients, id.Hex())
+	}
+	h.hub.SendToUsers(recipients, Event{Type: EventMessageNew, Data: saved}, s)
+
+	return saved, nil
+}
+
+func isParticipant(convo *model.Conversation, userID primitive.ObjectID) bool {
+	for _, id := range convo.Participants {
+		if id == userID {
+			return true
+		}
 	}
+	return false
 }
 
 type TCPRoom struct {
-	Type       string `json:"type"`
 	RoomID     string `json:"roomId,omitempty"`
-	PlayerID   string `json:"playerId,omitempty"`
 	PlayerName string `json:"playerName,omitempty"`
 }
 
-func handleRoom(msg string, deps Dependencies) string {
+func (h *commandHandlers) createRoom(s *Session, env Envelope) (interface{}, error) {
 	var cmd TCPRoom
-	if err := json.Unmarshal([]byte(msg), &cmd); err != nil {
-		return "Invalid JSON format"
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
 	}
 
-	switch cmd.Type {
-	case "create_room":
-
-		if cmd.RoomID == "" || cmd.PlayerID == "" || cmd.PlayerName == "" {
-			return "Missing roomId, playerId, or playerName"
-		}
-
-		player := &game.Player{
-			ID:     cmd.PlayerID,
-			Name:   cmd.PlayerName,
-			Health: 100,
-			Conn:   deps.Conn, // <-- you must pass conn in your Dependencies
-		}
-
-		game.AddPlayerToRoom(cmd.RoomID, player)
+	if cmd.RoomID == "" || cmd.PlayerName == "" {
+		return nil, NewCommandError(CodeBadRequest, "Missing roomId or playerName")
+	}
 
-		return "Room created and player joined: " + cmd.RoomID
+	player := &game.Player{
+		ID:     s.UserID(),
+		Name:   cmd.PlayerName,
+		Health: h.deps.Rooms.Rules().MaxHealth,
+		Conn:   s,
+	}
 
-	default:
-		return "Unknown command"
+	if err := h.deps.Rooms.AddPlayer(cmd.RoomID, player); err != nil {
+		return nil, gameError(err)
 	}
+	s.joinedRoom(cmd.RoomID, player)
 
+	return map[string]interface{}{
+		"roomId":   cmd.RoomID,
+		"playerId": player.ID,
+		"tickRate": h.deps.Rooms.Rules().TickRate,
+	}, nil
 }
@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/utils"
+)
+
+// signedToken signs a login token for userID with key, expiring at
+// expiresAt.
+func signedToken(t *testing.T, key, userID string, expiresAt time.Time) string {
+	t.Helper()
+	claims := &utils.Claims{UserID: userID, StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix()}}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	must(t, err)
+	return token
+}
+
+func TestAuth(t *testing.T) {
+	userID := primitive.NewObjectID().Hex()
+	key := os.Getenv("JWT_SECRET")
+	valid, err := utils.GenerateJWT(userID)
+	must(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"valid", valid, ""},
+		{"missing", "", CodeBadRequest},
+		{"malformed", "not-a-token", CodeUnauthorized},
+		{"expired", signedToken(t, key, userID, time.Now().Add(-time.Minute)), CodeUnauthorized},
+		{"wrong key", signedToken(t, key+"-other", userID, time.Now().Add(time.Hour)), CodeUnauthorized},
+		{"not a user ID", signedToken(t, key, "bot-7", time.Now().Add(time.Hour)), CodeUnauthorized},
+	}
+	srv := testServer(t, LoadConfig())
+	for _, tt := range tests {
+		c := pipeClient(t, srv, FrameModeLine)
+		c.hello()
+
+		var data map[string]interface{}
+		r := c.call("auth", "1", TCPAuth{Token: tt.token}, &data)
+		if tt.code == "" {
+			if !r.OK || data["userId"] != userID {
+				t.Errorf("%s: got %+v, %v; want user %s", tt.name, r.Error, data, userID)
+			}
+			continue
+		}
+		if r.Error == nil || r.Error.Code != tt.code {
+			t.Errorf("%s: error = %+v, want %s", tt.name, r.Error, tt.code)
+		}
+		if r := c.call("create_room", "2", TCPRoom{RoomID: "r", PlayerName: "p"}, nil); r.Error == nil || r.Error.Code != CodeUnauthorized {
+			t.Errorf("%s: command after a failed auth = %+v", tt.name, r.Error)
+		}
+	}
+}
+
+func TestAuthTimeout(t *testing.T) {
+	cfg := LoadConfig()
+	cfg.AuthTimeout = 50 * time.Millisecond
+	c := pipeClient(t, testServer(t, cfg), FrameModeLine)
+	c.hello()
+
+	if r := c.next(nil); r.Error == nil || r.Error.Code != CodeUnauthorized {
+		t.Errorf("frame = %+v, want an authentication timeout", r)
+	}
+	if !c.closed(2 * time.Second) {
+		t.Error("unauthenticated connection still open")
+	}
+}
+
+func TestIdentityComesFromTheSession(t *testing.T) {
+	userID := primitive.NewObjectID().Hex()
+	c := pipeClient(t, testServer(t, LoadConfig()), FrameModeLine)
+	c.hello()
+	c.auth(userID)
+
+	// Identity fields in the payload are ignored.
+	payload := map[string]string{"roomId": "r", "playerName": "p", "playerId": "someone", "userId": "someone", "senderId": "someone"}
+	var data map[string]interface{}
+	if r := c.call("create_room", "1", payload, &data); !r.OK || data["playerId"] != userID {
+		t.Errorf("create_room = %+v, %v; want player %s", r.Error, data, userID)
+	}
+
+	// Nor can the session switch to another user.
+	token, err := utils.GenerateJWT(primitive.NewObjectID().Hex())
+	must(t, err)
+	if r := c.call("auth", "2", TCPAuth{Token: token}, nil); r.Error == nil || r.Error.Code != CodeConflict {
+		t.Errorf("second auth = %+v, want %s", r.Error, CodeConflict)
+	}
+}
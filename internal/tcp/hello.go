@@ -0,0 +1,113 @@
+package tcp
+
+import "fmt"
+
+// ProtocolVersion is the newest protocol revision this server speaks. Bump
+// it whenever a command or event schema changes incompatibly.
+const ProtocolVersion = 1
+
+// Framing names used in hello capabilities.
+const (
+	FramingLine           = "line"
+	FramingLengthPrefixed = "length_prefixed"
+	FramingWebSocket      = "websocket"
+)
+
+// CompressionNone is the only compression the server currently offers.
+const CompressionNone = "none"
+
+// ClientHello is the payload of the hello command, the first command of
+// every session.
+type ClientHello struct {
+	ProtocolVersion int                `json:"protocolVersion"`
+	ClientBuild     string             `json:"clientBuild,omitempty"`
+	Capabilities    ClientCapabilities `json:"capabilities"`
+}
+
+// ClientCapabilities lists what the client supports, most preferred first.
+type ClientCapabilities struct {
+	Compression []string `json:"compression,omitempty"`
+	Codecs      []string `json:"codecs,omitempty"`
+	Framing     []string `json:"framing,omitempty"`
+}
+
+// ServerHello is the reply to hello with the negotiated features.
+type ServerHello struct {
+	ProtocolVersion    int    `json:"protocolVersion"`
+	MinProtocolVersion int    `json:"minProtocolVersion"`
+	Codec              string `json:"codec"`
+	Framing            string `json:"framing"`
+	Compression        string `json:"compression"`
+	MaxFrameSize       int    `json:"maxFrameSize"`
+	PingIntervalMs     int64  `json:"pingIntervalMs"`
+}
+
+func framingName(mode FrameMode) string {
+	switch mode {
+	case FrameModeLengthPrefixed:
+		return FramingLengthPrefixed
+	case FrameModeWebSocket:
+		return FramingWebSocket
+	default:
+		return FramingLine
+	}
+}
+
+// hello negotiates the protocol revision and features of the session.
+// Framing is fixed by the time hello is read, so it is only reported;
+// the first codec the client lists that the framing can carry decodes
+// the next client frame, and is used for server frames right after the
+// reply, which is still sent in JSON.
+func (h *commandHandlers) hello(s *Session, env Envelope) (interface{}, error) {
+	if s.Greeted() {
+		return nil, NewCommandError(CodeConflict, "hello already completed")
+	}
+
+	var cmd ClientHello
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	if cmd.ProtocolVersion < h.cfg.MinProtocolVersion {
+		s.closeAfter()
+		return nil, &CommandError{
+			Code:    CodeUpgradeNeeded,
+			Message: fmt.Sprintf("protocol version %d is no longer supported, please update the client", cmd.ProtocolVersion),
+			Details: map[string]interface{}{
+				"minProtocolVersion": h.cfg.MinProtocolVersion,
+				"protocolVersion":    ProtocolVersion,
+			},
+		}
+	}
+
+	version := cmd.ProtocolVersion
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+
+	codec := s.inCodec
+	for _, name := range cmd.Capabilities.Codecs {
+		c, ok := CodecByName(name)
+		if !ok || (c.Binary() && s.framer.Mode() == FrameModeLine) {
+			continue
+		}
+		codec = c
+		break
+	}
+	if codec != s.inCodec {
+		s.switchCodec(codec)
+	}
+
+	cmd.ProtocolVersion = version
+	s.setHello(&cmd)
+
+	return ServerHello{
+		ProtocolVersion:    version,
+		MinProtocolVersion: h.cfg.MinProtocolVersion,
+		Codec:              codec.Name(),
+		Framing:            framingName(s.framer.Mode()),
+		Compression:        CompressionNone,
+		MaxFrameSize:       h.cfg.MaxFrameSize,
+		PingIntervalMs:     h.cfg.PingInterval.Milliseconds(),
+	}, nil
+}
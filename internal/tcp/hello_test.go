@@ -0,0 +1,110 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHelloGatesOtherCommands(t *testing.T) {
+	c := pipeClient(t, testServer(t, LoadConfig()), FrameModeLine)
+
+	for _, cmdType := range []string{"auth", "resume", "set_codec", "create_room", "move"} {
+		if r := c.call(cmdType, cmdType, nil, nil); r.Error == nil || r.Error.Code != CodeHelloRequired {
+			t.Errorf("%s before hello = %+v, want %s", cmdType, r.Error, CodeHelloRequired)
+		}
+	}
+
+	c.hello()
+	tests := []struct {
+		cmdType string
+		code    string
+	}{
+		{"create_room", CodeUnauthorized},
+		{"hello", CodeConflict},
+		{"no_such_command", CodeUnknownCommand},
+	}
+	for _, tt := range tests {
+		if r := c.call(tt.cmdType, tt.cmdType, nil, nil); r.Error == nil || r.Error.Code != tt.code {
+			t.Errorf("%s after hello = %+v, want %s", tt.cmdType, r.Error, tt.code)
+		}
+	}
+}
+
+func TestHelloNegotiation(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       FrameMode
+		minVersion int
+		hello      ClientHello
+		code       string
+		want       ServerHello
+	}{
+		{
+			name:  "current version",
+			mode:  FrameModeLine,
+			hello: ClientHello{ProtocolVersion: ProtocolVersion},
+			want:  ServerHello{ProtocolVersion: ProtocolVersion, Codec: CodecJSON, Framing: FramingLine},
+		},
+		{
+			name:  "newer client",
+			mode:  FrameModeLine,
+			hello: ClientHello{ProtocolVersion: ProtocolVersion + 3},
+			want:  ServerHello{ProtocolVersion: ProtocolVersion, Codec: CodecJSON, Framing: FramingLine},
+		},
+		{
+			name:       "below the minimum",
+			mode:       FrameModeLine,
+			minVersion: ProtocolVersion + 1,
+			hello:      ClientHello{ProtocolVersion: ProtocolVersion},
+			code:       CodeUpgradeNeeded,
+		},
+		{
+			name: "binary codecs need length-prefixed framing",
+			mode: FrameModeLine,
+			hello: ClientHello{ProtocolVersion: ProtocolVersion,
+				Capabilities: ClientCapabilities{Codecs: []string{CodecProtobuf, CodecMsgpack, CodecJSON}}},
+			want: ServerHello{ProtocolVersion: ProtocolVersion, Codec: CodecJSON, Framing: FramingLine},
+		},
+		{
+			name: "first supported codec",
+			mode: FrameModeLengthPrefixed,
+			hello: ClientHello{ProtocolVersion: ProtocolVersion,
+				Capabilities: ClientCapabilities{Codecs: []string{"cbor", CodecMsgpack, CodecProtobuf}}},
+			want: ServerHello{ProtocolVersion: ProtocolVersion, Codec: CodecMsgpack, Framing: FramingLengthPrefixed},
+		},
+		{
+			name: "no supported codec",
+			mode: FrameModeLengthPrefixed,
+			hello: ClientHello{ProtocolVersion: ProtocolVersion,
+				Capabilities: ClientCapabilities{Codecs: []string{"cbor"}}},
+			want: ServerHello{ProtocolVersion: ProtocolVersion, Codec: CodecJSON, Framing: FramingLengthPrefixed},
+		},
+	}
+
+	for _, tt := range tests {
+		cfg := LoadConfig()
+		if tt.minVersion != 0 {
+			cfg.MinProtocolVersion = tt.minVersion
+		}
+		c := pipeClient(t, testServer(t, cfg), tt.mode)
+
+		var got ServerHello
+		r := c.call("hello", "1", tt.hello, &got)
+		if tt.code != "" {
+			if r.Error == nil || r.Error.Code != tt.code {
+				t.Errorf("%s: error = %+v, want %s", tt.name, r.Error, tt.code)
+			}
+			if !c.closed(2 * time.Second) {
+				t.Errorf("%s: connection still open", tt.name)
+			}
+			continue
+		}
+		if !r.OK {
+			t.Fatalf("%s: %+v", tt.name, r.Error)
+		}
+		if got.ProtocolVersion != tt.want.ProtocolVersion || got.Codec != tt.want.Codec || got.Framing != tt.want.Framing {
+			t.Errorf("%s: got version %d, codec %s, framing %s; want %d, %s, %s", tt.name,
+				got.ProtocolVersion, got.Codec, got.Framing, tt.want.ProtocolVersion, tt.want.Codec, tt.want.Framing)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package tcp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Hub tracks the live authenticated sessions of each user so server events
+// can be pushed to every device a user is connected from.
+type Hub struct {
+	mu       sync.RWMutex
+	sessions map[string]map[*Session]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{sessions: make(map[string]map[*Session]struct{})}
+}
+
+// Register adds an authenticated session under its user.
+func (h *Hub) Register(s *Session) {
+	userID := s.UserID()
+	if userID == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sessions[userID] == nil {
+		h.sessions[userID] = make(map[*Session]struct{})
+	}
+	h.sessions[userID][s] = struct{}{}
+}
+
+// Unregister removes s. It is safe to call for sessions that never
+// authenticated.
+func (h *Hub) Unregister(s *Session) {
+	userID := s.UserID()
+	if userID == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.sessions[userID], s)
+	if len(h.sessions[userID]) == 0 {
+		delete(h.sessions, userID)
+	}
+}
+
+// SessionsFor returns a snapshot of the live sessions of userID.
+func (h *Hub) SessionsFor(userID string) []*Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(h.sessions[userID]))
+	for s := range h.sessions[userID] {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// Online reports whether userID has at least one live session.
+func (h *Hub) Online(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.sessions[userID]) > 0
+}
+
+// SendToUsers pushes ev to every live session of userIDs except skip, which
+// is usually the session that triggered the event and already got a reply.
+func (h *Hub) SendToUsers(userIDs []string, ev Event, skip *Session) {
+	for _, userID := range userIDs {
+		for _, s := range h.SessionsFor(userID) {
+			if s == skip {
+				continue
+			}
+			if err := s.Send(ev); err != nil {
+				fmt.Println("Push error:", s.Conn.RemoteAddr(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package tcp
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// hubClient is the peer of a session of userID registered in hub.
+type hubClient struct {
+	session *Session
+	conn    net.Conn
+	frames  *json.Decoder
+}
+
+func newHubClient(t *testing.T, hub *Hub, userID string) *hubClient {
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	cfg := LoadConfig()
+	s := newSession(server, &lineFramer{w: server, maxSize: cfg.MaxFrameSize}, cfg)
+	t.Cleanup(s.abort)
+	s.setUserID(userID)
+	hub.Register(s)
+	return &hubClient{session: s, conn: client, frames: json.NewDecoder(client)}
+}
+
+// received reports whether an event of eventType arrived within wait.
+func (c *hubClient) received(eventType string, wait time.Duration) bool {
+	c.conn.SetReadDeadline(time.Now().Add(wait))
+	var ev Event
+	return c.frames.Decode(&ev) == nil && ev.Type == eventType
+}
+
+func TestSendToUsersReachesConnectedParticipants(t *testing.T) {
+	hub := NewHub()
+	sender := newHubClient(t, hub, "alice")
+	otherDevice := newHubClient(t, hub, "alice")
+	bob := newHubClient(t, hub, "bob")
+	outsider := newHubClient(t, hub, "carol")
+	gone := newHubClient(t, hub, "dave")
+
+	// Dave disconnects before the message is sent.
+	hub.Unregister(gone.session)
+	gone.session.abort()
+	if hub.Online("dave") {
+		t.Fatal("dave still online")
+	}
+
+	hub.SendToUsers([]string{"alice", "bob", "dave"}, Event{Type: EventMessageNew}, sender.session)
+
+	tests := []struct {
+		name   string
+		client *hubClient
+		want   bool
+	}{
+		{"other device", otherDevice, true},
+		{"participant", bob, true},
+		{"sender", sender, false},
+		{"non-participant", outsider, false},
+		{"disconnected", gone, false},
+	}
+	for _, tt := range tests {
+		wait := 2 * time.Second
+		if !tt.want {
+			wait = 50 * time.Millisecond
+		}
+		if got := tt.client.received(EventMessageNew, wait); got != tt.want {
+			t.Errorf("%s received message.new: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package tcp
+
+type TCPQueueJoin struct {
+	// Regions the player accepts, by preference, e.g. ["eu-west", "eu"].
+	Regions []string `json:"regions"`
+}
+
+// queueJoin puts the caller in the matchmaking queue, together with the
+// members who accepted their party invites; see party_invite. Once
+// matched, every member gets a match.found event with the room to join.
+func (h *commandHandlers) queueJoin(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPQueueJoin
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	if _, err := sessionUserID(s); err != nil {
+		return nil, err
+	}
+
+	players, err := h.parties.queueable(s.UserID())
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range players {
+		if !h.hub.Online(id) {
+			return nil, NewCommandError(CodeNotFound, "Party member is not online: "+id)
+		}
+	}
+
+	ticket, err := h.matchmaker.Join(players, cmd.Regions)
+	if err != nil {
+		return nil, gameError(err)
+	}
+	return ticket, nil
+}
+
+// queueLeave takes the caller's party out of the matchmaking queue.
+func (h *commandHandlers) queueLeave(s *Session, env Envelope) (interface{}, error) {
+	ticket, err := h.matchmaker.Leave(s.UserID())
+	if err != nil {
+		return nil, gameError(err)
+	}
+	return map[string]string{"ticketId": ticket.ID}, nil
+}
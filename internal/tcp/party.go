@@ -0,0 +1,247 @@
+package tcp
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/game"
+)
+
+// Party events, pushed to the users concerned.
+const (
+	// EventPartyInvite tells a user who invited them, to answer with
+	// party_accept.
+	EventPartyInvite = "party.invite"
+	// EventPartyUpdate carries the party after a member joined or left.
+	// Members who left get it too; an empty member list means the party
+	// was disbanded.
+	EventPartyUpdate = "party.update"
+)
+
+// Party is a leader and the members who accepted their invite.
+type Party struct {
+	LeaderID string   `json:"leaderId"`
+	Members  []string `json:"members"`
+}
+
+// parties groups users who agreed to queue together. Only members who
+// accepted an invite are ever part of a party, so a leader cannot queue
+// anyone else.
+type parties struct {
+	hub        *Hub
+	matchmaker *game.Matchmaker
+
+	mu       sync.Mutex
+	members  map[string][]string        // leader -> accepted members
+	leaderOf map[string]string          // member -> leader
+	invites  map[string]map[string]bool // invitee -> leaders who invited them
+}
+
+func newParties(hub *Hub, matchmaker *game.Matchmaker) *parties {
+	return &parties{
+		hub:        hub,
+		matchmaker: matchmaker,
+		members:    make(map[string][]string),
+		leaderOf:   make(map[string]string),
+		invites:    make(map[string]map[string]bool),
+	}
+}
+
+// invite lets userID join the party of leaderID, creating it if needed.
+func (p *parties) invite(leaderID, userID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if leaderID == userID {
+		return NewCommandError(CodeBadRequest, "You cannot invite yourself")
+	}
+	if _, member := p.leaderOf[leaderID]; member {
+		return NewCommandError(CodeConflict, "Only the party leader can invite")
+	}
+	if p.leaderOf[userID] == leaderID {
+		return NewCommandError(CodeConflict, "User is already in your party")
+	}
+
+	if p.invites[userID] == nil {
+		p.invites[userID] = make(map[string]bool)
+	}
+	p.invites[userID][leaderID] = true
+	return nil
+}
+
+// accept adds userID to the party of leaderID, which must have invited
+// them. It returns the party as it now stands.
+func (p *parties) accept(userID, leaderID string) (Party, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.invites[userID][leaderID] {
+		return Party{}, NewCommandError(CodeForbidden, "You were not invited to this party")
+	}
+	if _, member := p.leaderOf[userID]; member || len(p.members[userID]) > 0 {
+		return Party{}, NewCommandError(CodeConflict, "Leave your current party first")
+	}
+	if _, member := p.leaderOf[leaderID]; member {
+		// The leader joined another party since inviting.
+		delete(p.invites[userID], leaderID)
+		return Party{}, NewCommandError(CodeConflict, "This party no longer exists")
+	}
+
+	delete(p.invites[userID], leaderID)
+	if len(p.invites[userID]) == 0 {
+		delete(p.invites, userID)
+	}
+	p.members[leaderID] = append(p.members[leaderID], userID)
+	p.leaderOf[userID] = leaderID
+	return p.partyLocked(leaderID), nil
+}
+
+// leave takes userID out of their party, disbanding it if they lead it,
+// and drops the invites they sent. It returns the party as it was before,
+// and false if userID was in none.
+func (p *parties) leave(userID string) (Party, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for invitee, leaders := range p.invites {
+		delete(leaders, userID)
+		if len(leaders) == 0 {
+			delete(p.invites, invitee)
+		}
+	}
+
+	leaderID, member := p.leaderOf[userID]
+	if !member {
+		if len(p.members[userID]) == 0 {
+			return Party{}, false
+		}
+		leaderID = userID
+	}
+	before := p.partyLocked(leaderID)
+
+	if leaderID == userID {
+		for _, id := range p.members[leaderID] {
+			delete(p.leaderOf, id)
+		}
+		delete(p.members, leaderID)
+		return before, true
+	}
+
+	delete(p.leaderOf, userID)
+	kept := p.members[leaderID][:0]
+	for _, id := range p.members[leaderID] {
+		if id != userID {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) == 0 {
+		delete(p.members, leaderID)
+	} else {
+		p.members[leaderID] = kept
+	}
+	return before, true
+}
+
+// queueable returns who userID queues with: their party if they lead one,
+// or themselves alone. Party members cannot queue on their own.
+func (p *parties) queueable(userID string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, member := p.leaderOf[userID]; member {
+		return nil, NewCommandError(CodeConflict, "Only the party leader can join the queue")
+	}
+	return append([]string{userID}, p.members[userID]...), nil
+}
+
+// of returns the party userID is in, with themselves alone if none.
+func (p *parties) of(userID string) Party {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if leaderID, member := p.leaderOf[userID]; member {
+		return p.partyLocked(leaderID)
+	}
+	return p.partyLocked(userID)
+}
+
+// quit takes userID out of their party and the party out of the
+// matchmaking queue, then tells its members, except on skip. It reports
+// false if userID was in no party.
+func (p *parties) quit(userID string, skip *Session) bool {
+	before, ok := p.leave(userID)
+	if !ok {
+		return false
+	}
+	p.matchmaker.Leave(before.LeaderID)
+
+	after := p.of(before.LeaderID)
+	if before.LeaderID == userID {
+		after = Party{LeaderID: userID, Members: []string{}}
+	}
+	p.hub.SendToUsers(append([]string{before.LeaderID}, before.Members...), Event{Type: EventPartyUpdate, Data: after}, skip)
+	return true
+}
+
+func (p *parties) partyLocked(leaderID string) Party {
+	return Party{LeaderID: leaderID, Members: append([]string{}, p.members[leaderID]...)}
+}
+
+type TCPPartyInvite struct {
+	UserID string `json:"userId"`
+}
+
+type TCPPartyAccept struct {
+	LeaderID string `json:"leaderId"`
+}
+
+// partyInvite invites an online user to the caller's party. They get a
+// party.invite event and join with party_accept.
+func (h *commandHandlers) partyInvite(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPPartyInvite
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+	if _, err := primitive.ObjectIDFromHex(cmd.UserID); err != nil {
+		return nil, NewCommandError(CodeBadRequest, "Invalid userId")
+	}
+	if !h.hub.Online(cmd.UserID) {
+		return nil, NewCommandError(CodeNotFound, "User is not online")
+	}
+
+	if err := h.parties.invite(s.UserID(), cmd.UserID); err != nil {
+		return nil, err
+	}
+	h.hub.SendToUsers([]string{cmd.UserID}, Event{Type: EventPartyInvite, Data: map[string]string{"leaderId": s.UserID()}}, nil)
+	return h.parties.of(s.UserID()), nil
+}
+
+// partyAccept joins the party of a leader who invited the caller. Every
+// member gets a party.update event.
+func (h *commandHandlers) partyAccept(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPPartyAccept
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	party, err := h.parties.accept(s.UserID(), cmd.LeaderID)
+	if err != nil {
+		return nil, err
+	}
+	// A queued party no longer matches its members; it queues again.
+	h.matchmaker.Leave(cmd.LeaderID)
+	h.matchmaker.Leave(s.UserID())
+
+	h.hub.SendToUsers(append([]string{party.LeaderID}, party.Members...), Event{Type: EventPartyUpdate, Data: party}, s)
+	return party, nil
+}
+
+// partyLeave takes the caller out of their party; a leader leaving
+// disbands it.
+func (h *commandHandlers) partyLeave(s *Session, env Envelope) (interface{}, error) {
+	if !h.parties.quit(s.UserID(), s) {
+		return nil, NewCommandError(CodeNotFound, "You are not in a party")
+	}
+	return h.parties.of(s.UserID()), nil
+}
@@ -0,0 +1,95 @@
+package tcp
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/game"
+)
+
+// onlineSession returns an authenticated session registered in hub. Its
+// peer never reads, so it only holds what fits in the outbound queue.
+func onlineSession(t *testing.T, hub *Hub, cfg Config) *Session {
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	s := newSession(server, &lineFramer{w: server, maxSize: cfg.MaxFrameSize}, cfg)
+	t.Cleanup(s.abort)
+	s.setUserID(primitive.NewObjectID().Hex())
+	hub.Register(s)
+	return s
+}
+
+func command(t *testing.T, cmdType string, payload interface{}) Envelope {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Envelope{Type: cmdType, Payload: data}
+}
+
+func commandCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	return toReplyError(err).Code
+}
+
+func TestOnlyInvitedUsersAreQueued(t *testing.T) {
+	cfg := LoadConfig()
+	hub := NewHub()
+	matchmaker := game.NewMatchmaker(game.MatchmakerConfig{MatchSize: 4, MaxBand: 800}, game.NewRoomManager(game.DefaultRules), nil, nil)
+	h := &commandHandlers{hub: hub, cfg: cfg, matchmaker: matchmaker, parties: newParties(hub, matchmaker)}
+
+	leader, friend, stranger := onlineSession(t, hub, cfg), onlineSession(t, hub, cfg), onlineSession(t, hub, cfg)
+	regions := map[string]interface{}{"regions": []string{"eu"}}
+
+	// Joining uninvited fails, and naming a stranger in the request does
+	// not queue them.
+	if _, err := h.partyAccept(stranger, command(t, "party_accept", TCPPartyAccept{LeaderID: leader.UserID()})); commandCode(err) != CodeForbidden {
+		t.Fatalf("uninvited accept: got %v", err)
+	}
+	ticket, err := h.queueJoin(leader, command(t, "queue_join", map[string]interface{}{"regions": []string{"eu"}, "party": []string{stranger.UserID()}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := ticket.(game.Ticket).PlayerIDs; len(ids) != 1 || ids[0] != leader.UserID() {
+		t.Fatalf("queued %v, want the leader alone", ids)
+	}
+	if _, err := h.queueJoin(stranger, command(t, "queue_join", regions)); err != nil {
+		t.Fatalf("stranger blocked from queueing: %v", err)
+	}
+
+	// An invited user who accepts is queued with the leader, whose queued
+	// ticket is dropped as the party changed.
+	if _, err := h.partyInvite(leader, command(t, "party_invite", TCPPartyInvite{UserID: friend.UserID()})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.partyAccept(friend, command(t, "party_accept", TCPPartyAccept{LeaderID: leader.UserID()})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := matchmaker.Leave(leader.UserID()); err == nil {
+		t.Error("leader still queued after the party changed")
+	}
+	if _, err := h.queueJoin(friend, command(t, "queue_join", regions)); commandCode(err) != CodeConflict {
+		t.Errorf("member queued on their own: got %v", err)
+	}
+	ticket, err = h.queueJoin(leader, command(t, "queue_join", regions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := ticket.(game.Ticket).PlayerIDs; len(ids) != 2 || ids[1] != friend.UserID() {
+		t.Fatalf("queued %v, want the leader and friend", ids)
+	}
+
+	// Leaving the party takes it out of the queue.
+	if _, err := h.partyLeave(friend, Envelope{Type: "party_leave"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := matchmaker.Leave(friend.UserID()); err == nil {
+		t.Error("friend still queued after leaving the party")
+	}
+}
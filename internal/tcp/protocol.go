@@ -0,0 +1,148 @@
+package tcp
+
+import (
+	"errors"
+	"net/http"
+
+	"game_tcpserver/internal/utils"
+)
+
+// Envelope is every client request, {type, requestId, payload}, as decoded
+// by the session codec. In JSON:
+// {"type": "...", "requestId": "...", "payload": {...}}
+type Envelope struct {
+	Type      string
+	RequestID string
+	// Payload is still encoded with the codec that decoded the envelope;
+	// handlers decode it with Bind.
+	Payload []byte
+
+	codec Codec
+}
+
+// Bind decodes the envelope payload into v.
+func (e Envelope) Bind(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return NewCommandError(CodeBadRequest, "missing payload")
+	}
+
+	codec := e.codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+	if err := codec.Unmarshal(e.Payload, v); err != nil {
+		return NewCommandError(CodeBadRequest, "invalid payload: "+err.Error())
+	}
+	return nil
+}
+
+// Reply is sent back for every envelope so clients can match it by requestId.
+type Reply struct {
+	RequestID string      `json:"requestId,omitempty"`
+	OK        bool        `json:"ok"`
+	Error     *ReplyError `json:"error,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Event is pushed by the server without a matching request. Seq numbers the
+// events of an authenticated session so missed ones can be replayed after
+// a reconnect; heartbeats and state snapshots carry no Seq.
+type Event struct {
+	Type string      `json:"type"`
+	Seq  uint64      `json:"seq,omitempty"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Server-pushed event types
+const (
+	EventMessageNew = "message.new"
+	// EventRoomState carries game state snapshots. It travels in the
+	// droppable state lane of the outbound queue.
+	EventRoomState = "room.state"
+	// EventRoomDelta carries a snapshot encoded against the last one the
+	// client acknowledged with ack_state. It shares the state lane.
+	EventRoomDelta = "room.delta"
+	// EventMatchFound tells every matched player the room reserved for
+	// their match, to join with create_room.
+	EventMatchFound = "match.found"
+	// EventServerShutdown tells clients the server is going away and when
+	// to reconnect.
+	EventServerShutdown = "server.shutdown"
+)
+
+type ReplyError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details carries machine-readable context such as the minimum
+	// protocol version or a retry-after delay.
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Error codes returned in ReplyError.Code
+const (
+	CodeBadRequest     = "bad_request"
+	CodeUnknownCommand = "unknown_command"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeProtocolError  = "protocol_error"
+	CodeHelloRequired  = "hello_required"
+	CodeUpgradeNeeded  = "upgrade_required"
+	CodeShuttingDown   = "shutting_down"
+	CodeRateLimited    = "rate_limited"
+	CodeRejected       = "action_rejected"
+	CodeInternal       = "internal_error"
+)
+
+// CommandError is returned by handlers to control the code sent to the client.
+type CommandError struct {
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+func NewCommandError(code, message string) *CommandError {
+	return &CommandError{Code: code, Message: message}
+}
+
+func okReply(requestID string, data interface{}) Reply {
+	return Reply{RequestID: requestID, OK: true, Data: data}
+}
+
+func errorReply(requestID string, err error) Reply {
+	return Reply{RequestID: requestID, OK: false, Error: toReplyError(err)}
+}
+
+// toReplyError maps handler errors, including the service layer's
+// utils.CustomError, onto protocol error codes.
+func toReplyError(err error) *ReplyError {
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		return &ReplyError{Code: cmdErr.Code, Message: cmdErr.Message, Details: cmdErr.Details}
+	}
+
+	var customErr *utils.CustomError
+	if errors.As(err, &customErr) {
+		code := CodeInternal
+		switch customErr.HTTPStatusCode {
+		case http.StatusBadRequest:
+			code = CodeBadRequest
+		case http.StatusUnauthorized:
+			code = CodeUnauthorized
+		case http.StatusForbidden:
+			code = CodeForbidden
+		case http.StatusNotFound:
+			code = CodeNotFound
+		case http.StatusConflict:
+			code = CodeConflict
+		}
+		return &ReplyError{Code: code, Message: customErr.Message}
+	}
+
+	return &ReplyError{Code: CodeInternal, Message: err.Error()}
+}
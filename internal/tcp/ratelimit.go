@@ -0,0 +1,257 @@
+package tcp
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitDefault is the RateLimits key applied to commands without a
+// limit of their own.
+const RateLimitDefault = "*"
+
+// RateLimit is a token bucket: Burst commands at once, refilled at Rate
+// commands per second. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits maps command types to their limit.
+type RateLimits map[string]RateLimit
+
+func (l RateLimits) forCommand(command string) (key string, limit RateLimit, ok bool) {
+	if limit, ok := l[command]; ok {
+		return command, limit, limit.Rate > 0
+	}
+	limit, ok = l[RateLimitDefault]
+	return RateLimitDefault, limit, ok && limit.Rate > 0
+}
+
+var (
+	rateLimitMetrics = expvar.NewMap("tcp_rate_limit")
+
+	throttledCommands   = new(expvar.Int)
+	throttleDisconnects = new(expvar.Int)
+)
+
+func init() {
+	rateLimitMetrics.Set("throttled", throttledCommands)
+	rateLimitMetrics.Set("disconnects", throttleDisconnects)
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// refill adds the tokens earned since the last call and returns how long
+// until a token is available.
+func (b *tokenBucket) refill(now time.Time) time.Duration {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+func (b *tokenBucket) full() bool {
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// sessionLimits holds the buckets and offences of one session. It is only
+// used by the session's read loop.
+type sessionLimits struct {
+	buckets     map[string]*tokenBucket
+	violations  int
+	windowStart time.Time
+}
+
+// rateLimiter applies the per-session and per-user limits. User buckets are
+// shared by all connections of a user and dropped once refilled.
+type rateLimiter struct {
+	sessionLimits RateLimits
+	userLimits    RateLimits
+	maxViolations int
+	window        time.Duration
+
+	mu        sync.Mutex
+	users     map[string]map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg Config) *rateLimiter {
+	return &rateLimiter{
+		sessionLimits: cfg.SessionRateLimits,
+		userLimits:    cfg.UserRateLimits,
+		maxViolations: cfg.RateLimitMaxViolations,
+		window:        cfg.RateLimitViolationWindow,
+		users:         make(map[string]map[string]*tokenBucket),
+		lastSweep:     time.Now(),
+	}
+}
+
+// allow takes a token for command from the session and, once
+// authenticated, from its user. A throttled command returns a rate_limited
+// error and whether the session has been throttled often enough to be
+// disconnected.
+func (l *rateLimiter) allow(s *Session, command string) (*CommandError, bool) {
+	now := time.Now()
+
+	var sessionBucket, userBucket *tokenBucket
+	scope := ""
+	var retryAfter time.Duration
+
+	if key, limit, ok := l.sessionLimits.forCommand(command); ok {
+		sessionBucket = s.limits.bucket(key, limit, now)
+		if wait := sessionBucket.refill(now); wait > 0 {
+			scope, retryAfter = "session", wait
+		}
+	}
+
+	if userID := s.UserID(); userID != "" {
+		if key, limit, ok := l.userLimits.forCommand(command); ok {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.sweepLocked(now)
+			userBucket = l.userBucketLocked(userID, key, limit, now)
+			if wait := userBucket.refill(now); wait > retryAfter {
+				scope, retryAfter = "user", wait
+			}
+		}
+	}
+
+	if retryAfter == 0 {
+		if sessionBucket != nil {
+			sessionBucket.tokens--
+		}
+		if userBucket != nil {
+			userBucket.tokens--
+		}
+		return nil, false
+	}
+
+	throttledCommands.Add(1)
+	disconnect := s.limits.violation(now, l.window) > l.maxViolations
+	if disconnect {
+		throttleDisconnects.Add(1)
+	}
+
+	message := fmt.Sprintf("too many %s commands, retry later", command)
+	if disconnect {
+		message = "too many throttled commands, disconnecting"
+	}
+	return &CommandError{
+		Code:    CodeRateLimited,
+		Message: message,
+		Details: map[string]interface{}{
+			"command":      command,
+			"scope":        scope,
+			"retryAfterMs": int64(math.Ceil(float64(retryAfter) / float64(time.Millisecond))),
+			"disconnect":   disconnect,
+		},
+	}, disconnect
+}
+
+func (sl *sessionLimits) bucket(key string, limit RateLimit, now time.Time) *tokenBucket {
+	if sl.buckets == nil {
+		sl.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := sl.buckets[key]
+	if !ok {
+		b = newTokenBucket(limit, now)
+		sl.buckets[key] = b
+	}
+	return b
+}
+
+// violation records a throttled command and returns how many there were
+// in the current window.
+func (sl *sessionLimits) violation(now time.Time, window time.Duration) int {
+	if now.Sub(sl.windowStart) > window {
+		sl.windowStart = now
+		sl.violations = 0
+	}
+	sl.violations++
+	return sl.violations
+}
+
+func (l *rateLimiter) userBucketLocked(userID, key string, limit RateLimit, now time.Time) *tokenBucket {
+	buckets, ok := l.users[userID]
+	if !ok {
+		buckets = make(map[string]*tokenBucket)
+		l.users[userID] = buckets
+	}
+	b, ok := buckets[key]
+	if !ok {
+		b = newTokenBucket(limit, now)
+		buckets[key] = b
+	}
+	return b
+}
+
+// sweepLocked drops refilled user buckets, which are equivalent to new
+// ones, about once a minute.
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for userID, buckets := range l.users {
+		for key, b := range buckets {
+			b.refill(now)
+			if b.full() {
+				delete(buckets, key)
+			}
+		}
+		if len(buckets) == 0 {
+			delete(l.users, userID)
+		}
+	}
+}
+
+// parseRateLimits reads "command=rate:burst" entries separated by commas,
+// e.g. "send_message=5:10,*=20:40", on top of defaults. A rate of 0
+// disables the limit of that command.
+func parseRateLimits(spec string, defaults RateLimits) (RateLimits, error) {
+	limits := make(RateLimits, len(defaults))
+	for command, limit := range defaults {
+		limits[command] = limit
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		command, value, ok := strings.Cut(entry, "=")
+		rateStr, burstStr, ok2 := strings.Cut(value, ":")
+		if !ok || !ok2 {
+			return defaults, fmt.Errorf("invalid rate limit %q, want command=rate:burst", entry)
+		}
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate < 0 {
+			return defaults, fmt.Errorf("invalid rate in %q", entry)
+		}
+		burst, err := strconv.Atoi(burstStr)
+		if err != nil || burst < 1 {
+			return defaults, fmt.Errorf("invalid burst in %q", entry)
+		}
+		limits[strings.TrimSpace(command)] = RateLimit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
@@ -0,0 +1,116 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/utils"
+)
+
+func TestRateLimiterScopes(t *testing.T) {
+	l := newRateLimiter(Config{
+		SessionRateLimits:        RateLimits{"send_message": {Rate: 0.001, Burst: 3}},
+		UserRateLimits:           RateLimits{"send_message": {Rate: 0.001, Burst: 4}},
+		RateLimitMaxViolations:   2,
+		RateLimitViolationWindow: time.Minute,
+	})
+
+	first := &Session{userID: "u1"}
+	for i := 0; i < 3; i++ {
+		if err, _ := l.allow(first, "send_message"); err != nil {
+			t.Fatalf("command %d throttled: %v", i, err)
+		}
+	}
+	err, disconnect := l.allow(first, "send_message")
+	if err == nil || err.Code != CodeRateLimited || disconnect {
+		t.Fatalf("4th command: got %v, disconnect %v; want rate_limited", err, disconnect)
+	}
+	if err.Details["scope"] != "session" || err.Details["retryAfterMs"].(int64) <= 0 {
+		t.Errorf("details = %v", err.Details)
+	}
+
+	// Unlimited commands are never throttled.
+	if err, _ := l.allow(first, "ping"); err != nil {
+		t.Errorf("ping throttled: %v", err)
+	}
+
+	// The user bucket is shared with the user's other connections.
+	second := &Session{userID: "u1"}
+	if err, _ := l.allow(second, "send_message"); err != nil {
+		t.Fatalf("second connection throttled early: %v", err)
+	}
+	err, _ = l.allow(second, "send_message")
+	if err == nil || err.Details["scope"] != "user" {
+		t.Fatalf("got %v, want a user scoped rate_limited error", err)
+	}
+
+	// Another user is unaffected.
+	if err, _ := l.allow(&Session{userID: "u2"}, "send_message"); err != nil {
+		t.Errorf("other user throttled: %v", err)
+	}
+
+	// Repeat offenders are disconnected past RateLimitMaxViolations.
+	if _, disconnect := l.allow(first, "send_message"); disconnect {
+		t.Error("second violation disconnected")
+	}
+	if _, disconnect := l.allow(first, "send_message"); !disconnect {
+		t.Error("third violation did not disconnect")
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	defaults := RateLimits{"send_message": {Rate: 5, Burst: 10}, RateLimitDefault: {Rate: 20, Burst: 40}}
+
+	limits, err := parseRateLimits("send_message=1.5:3, create_room=0:1", defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := limits["send_message"]; got != (RateLimit{Rate: 1.5, Burst: 3}) {
+		t.Errorf("send_message = %+v", got)
+	}
+	if _, _, ok := limits.forCommand("create_room"); ok {
+		t.Error("create_room=0 should disable its limit")
+	}
+	if key, _, ok := limits.forCommand("hello"); !ok || key != RateLimitDefault {
+		t.Errorf("hello uses %q, %v; want the default limit", key, ok)
+	}
+
+	for _, spec := range []string{"send_message", "send_message=x:1", "send_message=1:0"} {
+		if _, err := parseRateLimits(spec, defaults); err == nil {
+			t.Errorf("%q parsed without error", spec)
+		}
+	}
+}
+
+func TestThrottledSessionsCannotBeResumed(t *testing.T) {
+	cfg := LoadConfig()
+	cfg.SessionRateLimits = RateLimits{"ping": {Rate: 0.001, Burst: 1}}
+	cfg.RateLimitMaxViolations = 0
+	srv := testServer(t, cfg)
+
+	c := pipeClient(t, srv, FrameModeLine)
+	c.hello()
+	token, err := utils.GenerateJWT(primitive.NewObjectID().Hex())
+	must(t, err)
+	var auth map[string]interface{}
+	if r := c.call("auth", "1", TCPAuth{Token: token}, &auth); !r.OK {
+		t.Fatalf("auth: %+v", r.Error)
+	}
+
+	c.call("ping", "2", nil, nil)
+	if r := c.call("ping", "3", nil, nil); r.Error == nil || r.Error.Details["disconnect"] != true {
+		t.Fatalf("flood = %+v, want a disconnecting rate_limited error", r.Error)
+	}
+	if !c.closed(2 * time.Second) {
+		t.Fatal("throttled connection still open")
+	}
+
+	// Resuming would hand the flooder fresh buckets.
+	again := pipeClient(t, srv, FrameModeLine)
+	again.hello()
+	if r := again.call("resume", "1", TCPResume{ResumeToken: auth["resumeToken"].(string)}, nil); r.Error == nil || r.Error.Code != CodeNotFound {
+		t.Errorf("resume = %+v, want %s", r.Error, CodeNotFound)
+	}
+}
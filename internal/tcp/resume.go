@@ -0,0 +1,248 @@
+package tcp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// outbox keeps the most recent events sent to a session, numbered from 1,
+// so they can be replayed after a reconnect.
+type outbox struct {
+	mu      sync.Mutex
+	events  []Event // ring buffer
+	start   int     // index of the oldest event
+	count   int
+	lastSeq uint64
+}
+
+func newOutbox(size int) *outbox {
+	return &outbox{events: make([]Event, size)}
+}
+
+// push numbers ev, stores it and returns it with its sequence number.
+func (o *outbox) push(ev Event) Event {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.lastSeq++
+	ev.Seq = o.lastSeq
+
+	if len(o.events) == 0 {
+		return ev
+	}
+	if o.count < len(o.events) {
+		o.events[(o.start+o.count)%len(o.events)] = ev
+		o.count++
+	} else {
+		o.events[o.start] = ev
+		o.start = (o.start + 1) % len(o.events)
+	}
+	return ev
+}
+
+// since returns the stored events after seq, oldest first. complete is
+// false when some of them have already been evicted.
+func (o *outbox) since(seq uint64) (events []Event, complete bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	complete = true
+	for i := 0; i < o.count; i++ {
+		ev := o.events[(o.start+i)%len(o.events)]
+		if ev.Seq <= seq {
+			continue
+		}
+		if len(events) == 0 && ev.Seq > seq+1 {
+			complete = false
+		}
+		events = append(events, ev)
+	}
+	if len(events) == 0 && o.lastSeq > seq {
+		complete = false
+	}
+	return events, complete
+}
+
+// resumeRegistry indexes authenticated sessions by resume token. Live
+// sessions can be taken over directly, which covers half-open connections
+// the server has not noticed yet; closed sessions stay parked for the
+// grace window and are then expired.
+type resumeRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*resumable
+	expire   func(*Session)
+}
+
+type resumable struct {
+	session *Session
+	timer   *time.Timer // set once the session is parked
+}
+
+func newResumeRegistry(expire func(*Session)) *resumeRegistry {
+	return &resumeRegistry{
+		sessions: make(map[string]*resumable),
+		expire:   expire,
+	}
+}
+
+func (r *resumeRegistry) register(s *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions[s.ResumeToken()] = &resumable{session: s}
+}
+
+// park keeps the closed session s resumable for grace, then expires it.
+func (r *resumeRegistry) park(s *Session, grace time.Duration) {
+	token := s.ResumeToken()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[token] = &resumable{
+		session: s,
+		timer: time.AfterFunc(grace, func() {
+			if r.take(token) != nil {
+				r.expire(s)
+			}
+		}),
+	}
+}
+
+// take removes and returns the session registered under token, or nil.
+func (r *resumeRegistry) take(token string) *Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.sessions[token]
+	if !ok {
+		return nil
+	}
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	delete(r.sessions, token)
+	return entry.session
+}
+
+func newResumeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// startResumable gives a freshly authenticated session its outbox and
+// resume token.
+func (h *commandHandlers) startResumable(s *Session) error {
+	token, err := newResumeToken()
+	if err != nil {
+		return err
+	}
+	s.setResumeToken(token)
+
+	s.writeMu.Lock()
+	s.outbox = newOutbox(h.cfg.ResumeOutboxSize)
+	s.writeMu.Unlock()
+
+	h.resumes.register(s)
+	return nil
+}
+
+type TCPResume struct {
+	ResumeToken string `json:"resumeToken"`
+	LastSeq     uint64 `json:"lastSeq"`
+}
+
+type ResumeResult struct {
+	UserID      string   `json:"userId"`
+	ResumeToken string   `json:"resumeToken"`
+	Rooms       []string `json:"rooms"`
+	Replayed    int      `json:"replayed"`
+	// Complete is false when events after lastSeq were already evicted from
+	// the outbox; the client must then resync its state from scratch.
+	Complete bool `json:"complete"`
+}
+
+// resume takes over a session that is still open or disconnected less than
+// ResumeGrace ago: its user, its room seats and its event stream. The
+// events after lastSeq are replayed in order right after the reply.
+func (h *commandHandlers) resume(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPResume
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	if s.Authenticated() {
+		return nil, NewCommandError(CodeConflict, "Session is already authenticated")
+	}
+
+	old := h.resumes.take(cmd.ResumeToken)
+	if old == nil {
+		return nil, NewCommandError(CodeNotFound, "Unknown or expired resume token, authenticate again")
+	}
+
+	token, err := newResumeToken()
+	if err != nil {
+		// Put the old session back so the client can retry.
+		h.resumes.register(old)
+		return nil, err
+	}
+
+	// From now on anything sent to the old session goes to s, which only
+	// records events until the replay below has been written.
+	old.writeMu.Lock()
+	s.writeMu.Lock()
+	s.outbox = old.outbox
+	s.replaying = true
+	old.successor = s
+	s.writeMu.Unlock()
+	old.writeMu.Unlock()
+
+	// A still-open old connection is dropped; with a successor set, its
+	// cleanup leaves the transferred state alone.
+	old.Close()
+
+	s.setUserID(old.UserID())
+	s.setResumeToken(token)
+	h.resumes.register(s)
+
+	h.hub.Register(s)
+	h.hub.Unregister(old)
+
+	rooms := []string{}
+	for roomID, p := range old.roomSeats() {
+		if h.deps.Rooms.ReattachPlayer(roomID, p, s) {
+			s.joinedRoom(roomID, p)
+			rooms = append(rooms, roomID)
+		}
+	}
+
+	missed, complete := s.outbox.since(cmd.LastSeq)
+
+	s.onReplySent(func() {
+		// Events recorded while the reply was queued are replayed too,
+		// since they are already in the outbox. Later ones are queued
+		// normally and written after the replay.
+		s.writeMu.Lock()
+		pending, _ := s.outbox.since(cmd.LastSeq)
+		s.replaying = false
+		s.writeMu.Unlock()
+
+		for _, ev := range pending {
+			if err := s.write(ev); err != nil {
+				break
+			}
+		}
+	})
+
+	return ResumeResult{
+		UserID:      s.UserID(),
+		ResumeToken: token,
+		Rooms:       rooms,
+		Replayed:    len(missed),
+		Complete:    complete,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package tcp
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func seqs(events []Event) []uint64 {
+	out := []uint64{}
+	for _, ev := range events {
+		out = append(out, ev.Seq)
+	}
+	return out
+}
+
+func TestOutboxSince(t *testing.T) {
+	o := newOutbox(3)
+	for i := 0; i < 5; i++ {
+		o.push(Event{Type: EventMessageNew})
+	}
+
+	// Events 1 and 2 were evicted when the ring wrapped.
+	tests := []struct {
+		seq      uint64
+		want     []uint64
+		complete bool
+	}{
+		{5, []uint64{}, true},
+		{4, []uint64{5}, true},
+		{2, []uint64{3, 4, 5}, true},
+		{1, []uint64{3, 4, 5}, false},
+		{0, []uint64{3, 4, 5}, false},
+	}
+	for _, tt := range tests {
+		events, complete := o.since(tt.seq)
+		if got := seqs(events); !reflect.DeepEqual(got, tt.want) || complete != tt.complete {
+			t.Errorf("since(%d) = %v, %v; want %v, %v", tt.seq, got, complete, tt.want, tt.complete)
+		}
+	}
+
+	// Without room to keep events, anything missed is lost.
+	empty := newOutbox(0)
+	if ev := empty.push(Event{}); ev.Seq != 1 {
+		t.Errorf("seq = %d, want 1", ev.Seq)
+	}
+	if events, complete := empty.since(0); len(events) != 0 || complete {
+		t.Errorf("since(0) = %v, %v; want nothing and incomplete", events, complete)
+	}
+}
+
+func TestResumeReplaysMissedEventsInOrder(t *testing.T) {
+	cfg := LoadConfig()
+	hub := NewHub()
+	h := &commandHandlers{hub: hub, cfg: cfg, resumes: newResumeRegistry(func(*Session) {})}
+
+	old := onlineSession(t, hub, cfg)
+	if err := h.startResumable(old); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		old.Send(Event{Type: EventMessageNew})
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	s := newSession(server, &lineFramer{w: server, maxSize: cfg.MaxFrameSize}, cfg)
+	defer s.abort()
+
+	result, err := h.resume(s, command(t, "resume", TCPResume{ResumeToken: old.ResumeToken(), LastSeq: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := result.(ResumeResult); r.UserID != old.UserID() || r.Replayed != 2 || !r.Complete {
+		t.Fatalf("result = %+v", r)
+	}
+
+	// Sent after the takeover but before the reply: recorded, then replayed
+	// after the events it follows.
+	old.Send(Event{Type: EventMessageNew})
+	s.sendReply(Reply{RequestID: "1", OK: true})
+	s.Send(Event{Type: EventMessageNew})
+
+	frames := json.NewDecoder(client)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var reply Reply
+	if err := frames.Decode(&reply); err != nil || reply.RequestID != "1" {
+		t.Fatalf("first frame = %+v, %v; want the reply", reply, err)
+	}
+	for want := uint64(2); want <= 5; want++ {
+		var ev Event
+		if err := frames.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Seq != want {
+			t.Fatalf("event seq %d, want %d", ev.Seq, want)
+		}
+	}
+}
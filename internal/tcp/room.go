@@ -0,0 +1,214 @@
+package tcp
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"game_tcpserver/internal/game"
+)
+
+// Movement and attack inputs carry the client's sequence number in Seq.
+// Inputs at or below the last one processed are rejected as stale_input,
+// and state updates report the last processed one in lastInputSeq.
+type TCPMove struct {
+	RoomID string  `json:"roomId"`
+	Seq    uint64  `json:"seq,omitempty"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+}
+
+type TCPAttack struct {
+	RoomID   string `json:"roomId"`
+	Seq      uint64 `json:"seq,omitempty"`
+	TargetID string `json:"targetId"`
+	// ViewTime is the serverTime, in unix milliseconds, of the state the
+	// client was rendering when the player fired. Targets are rewound to
+	// it, up to the room's maximum rewind.
+	ViewTime int64 `json:"viewTime,omitempty"`
+}
+
+type TCPLeaveRoom struct {
+	RoomID string `json:"roomId"`
+}
+
+type TCPReady struct {
+	RoomID string `json:"roomId"`
+	Ready  bool   `json:"ready"`
+}
+
+type TCPAckState struct {
+	RoomID string `json:"roomId"`
+	Tick   uint64 `json:"tick"`
+}
+
+type AttackResult struct {
+	Attacker game.PlayerState `json:"attacker"`
+	Target   game.PlayerState `json:"target"`
+	RewindMs int64            `json:"rewindMs"`
+}
+
+// seatIn returns the caller's own seat in roomID. Commands only ever act on
+// that seat, so a client cannot move or attack as another player.
+func seatIn(s *Session, roomID string) (*game.Player, error) {
+	if roomID == "" {
+		return nil, NewCommandError(CodeBadRequest, "Missing roomId")
+	}
+	seat := s.roomSeat(roomID)
+	if seat == nil {
+		return nil, NewCommandError(CodeForbidden, "You are not in this room")
+	}
+	return seat, nil
+}
+
+// move asks to move the caller to (x, y). The reply is sent once the room
+// has applied the move on its next tick and carries the authoritative
+// position, also when the move is rejected.
+func (h *commandHandlers) move(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPMove
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+	if math.IsNaN(cmd.X) || math.IsNaN(cmd.Y) || math.IsInf(cmd.X, 0) || math.IsInf(cmd.Y, 0) {
+		return nil, NewCommandError(CodeBadRequest, "Invalid coordinates")
+	}
+
+	seat, err := seatIn(s, cmd.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Deferred(func(reply func(interface{}, error)) {
+		err := h.deps.Rooms.MovePlayer(cmd.RoomID, seat.ID, cmd.Seq, cmd.X, cmd.Y, func(res game.InputResult) {
+			if res.Err != nil {
+				reply(nil, gameError(res.Err))
+				return
+			}
+			reply(res.Player, nil)
+		})
+		if err != nil {
+			reply(nil, gameError(err))
+		}
+	}), nil
+}
+
+// attack hits targetId with the room's attack if it was in range at
+// viewTime and the attacker is off cooldown; damage is decided by the
+// server. Like move, it replies once the room's next tick has run.
+func (h *commandHandlers) attack(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPAttack
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+	if cmd.TargetID == "" {
+		return nil, NewCommandError(CodeBadRequest, "Missing targetId")
+	}
+
+	seat, err := seatIn(s, cmd.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	var viewTime time.Time
+	if cmd.ViewTime > 0 {
+		viewTime = time.UnixMilli(cmd.ViewTime)
+	}
+
+	return Deferred(func(reply func(interface{}, error)) {
+		err := h.deps.Rooms.Attack(cmd.RoomID, seat.ID, cmd.Seq, cmd.TargetID, viewTime, func(res game.InputResult) {
+			if res.Err != nil {
+				reply(nil, gameError(res.Err))
+				return
+			}
+			reply(AttackResult{Attacker: res.Player, Target: res.Target, RewindMs: res.Rewind.Milliseconds()}, nil)
+		})
+		if err != nil {
+			reply(nil, gameError(err))
+		}
+	}), nil
+}
+
+func (h *commandHandlers) leaveRoom(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPLeaveRoom
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	seat, err := seatIn(s, cmd.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	h.deps.Rooms.RemovePlayer(cmd.RoomID, seat)
+	s.leftRoom(cmd.RoomID)
+
+	return map[string]string{"roomId": cmd.RoomID, "playerId": seat.ID}, nil
+}
+
+// ready sets the caller's ready flag in the room lobby. Room members get a
+// room.ready event, and room.transition events as the countdown starts or
+// is cancelled.
+func (h *commandHandlers) ready(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPReady
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	seat, err := seatIn(s, cmd.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.deps.Rooms.SetReady(cmd.RoomID, seat.ID, cmd.Ready); err != nil {
+		return nil, gameError(err)
+	}
+	return map[string]interface{}{"roomId": cmd.RoomID, "ready": cmd.Ready}, nil
+}
+
+// ackState acknowledges the room.state or room.delta of tick. Later deltas
+// are encoded against it; clients may ack every tick or only some.
+func (h *commandHandlers) ackState(s *Session, env Envelope) (interface{}, error) {
+	var cmd TCPAckState
+	if err := env.Bind(&cmd); err != nil {
+		return nil, err
+	}
+
+	seat, err := seatIn(s, cmd.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.deps.Rooms.AckSnapshot(cmd.RoomID, seat.ID, cmd.Tick); err != nil {
+		return nil, gameError(err)
+	}
+	return nil, nil
+}
+
+// gameError maps errors from the game package to reply codes.
+func gameError(err error) error {
+	var rejected *game.ActionError
+	switch {
+	case errors.As(err, &rejected):
+		details := map[string]interface{}{
+			"reason": rejected.Reason,
+			"player": rejected.Player,
+		}
+		if rejected.RetryAfter > 0 {
+			details["retryAfterMs"] = rejected.RetryAfter.Milliseconds()
+		}
+		return &CommandError{Code: CodeRejected, Message: rejected.Message, Details: details}
+	case errors.Is(err, game.ErrRoomNotFound), errors.Is(err, game.ErrPlayerNotFound), errors.Is(err, game.ErrTargetNotFound):
+		return NewCommandError(CodeNotFound, err.Error())
+	case errors.Is(err, game.ErrRoomFull), errors.Is(err, game.ErrMatchInProgress),
+		errors.Is(err, game.ErrRoomExists), errors.Is(err, game.ErrAlreadyQueued):
+		return NewCommandError(CodeConflict, err.Error())
+	case errors.Is(err, game.ErrNotReserved):
+		return NewCommandError(CodeForbidden, err.Error())
+	case errors.Is(err, game.ErrNotQueued):
+		return NewCommandError(CodeNotFound, err.Error())
+	case errors.Is(err, game.ErrPartyTooLarge), errors.Is(err, game.ErrNoRegion), errors.Is(err, game.ErrEmptyParty):
+		return NewCommandError(CodeBadRequest, err.Error())
+	default:
+		return err
+	}
+}
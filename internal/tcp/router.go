@@ -0,0 +1,86 @@
+package tcp
+
+import "fmt"
+
+// HandlerFunc handles a single command type. The returned value is sent to
+// the client as Reply.Data; a non-nil error becomes Reply.Error.
+type HandlerFunc func(s *Session, env Envelope) (interface{}, error)
+
+// Deferred is returned as data by handlers whose outcome is only known
+// after they return, such as inputs applied on the next room tick. It is
+// started with a reply function to call exactly once, from any goroutine,
+// while the read loop goes on with the next command.
+type Deferred func(reply func(data interface{}, err error))
+
+type route struct {
+	handler   HandlerFunc
+	public    bool
+	handshake bool
+}
+
+// Router maps each command type to exactly one handler.
+type Router struct {
+	routes map[string]route
+}
+
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]route)}
+}
+
+// Handle registers h for cmdType. The command is only dispatched for
+// authenticated sessions.
+func (r *Router) Handle(cmdType string, h HandlerFunc) {
+	r.register(cmdType, route{handler: h})
+}
+
+// HandlePublic registers h for cmdType without requiring authentication.
+func (r *Router) HandlePublic(cmdType string, h HandlerFunc) {
+	r.register(cmdType, route{handler: h, public: true})
+}
+
+// HandleHandshake registers h for cmdType as a command accepted before the
+// session has said hello: the hello exchange itself, and commands no
+// protocol version changes. Every other command gets hello_required.
+func (r *Router) HandleHandshake(cmdType string, h HandlerFunc) {
+	r.register(cmdType, route{handler: h, public: true, handshake: true})
+}
+
+// register panics when cmdType is registered twice, which is always a
+// programming error.
+func (r *Router) register(cmdType string, rt route) {
+	if _, exists := r.routes[cmdType]; exists {
+		panic(fmt.Sprintf("tcp: handler already registered for %q", cmdType))
+	}
+	r.routes[cmdType] = rt
+}
+
+// Dispatch runs the handler registered for env.Type and builds the reply,
+// unless the handler returned a Deferred, which is returned instead for
+// the caller to start.
+func (r *Router) Dispatch(s *Session, env Envelope) (Reply, Deferred) {
+	if env.Type == "" {
+		return errorReply(env.RequestID, NewCommandError(CodeBadRequest, "missing type")), nil
+	}
+
+	rt, ok := r.routes[env.Type]
+	if !ok {
+		return errorReply(env.RequestID, NewCommandError(CodeUnknownCommand, "unknown command: "+env.Type)), nil
+	}
+
+	if !rt.handshake && !s.Greeted() {
+		return errorReply(env.RequestID, NewCommandError(CodeHelloRequired, "send hello first")), nil
+	}
+
+	if !rt.public && !s.Authenticated() {
+		return errorReply(env.RequestID, NewCommandError(CodeUnauthorized, "authenticate first")), nil
+	}
+
+	data, err := rt.handler(s, env)
+	if err != nil {
+		return errorReply(env.RequestID, err), nil
+	}
+	if later, ok := data.(Deferred); ok {
+		return Reply{}, later
+	}
+	return okReply(env.RequestID, data), nil
+}
@@ -0,0 +1,135 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRouterDispatch(t *testing.T) {
+	r := NewRouter()
+	echo := func(s *Session, env Envelope) (interface{}, error) { return env.Type, nil }
+	r.HandlePublic("public", echo)
+	r.Handle("private", echo)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	cfg := LoadConfig()
+	s := newSession(server, &lineFramer{w: server, maxSize: cfg.MaxFrameSize}, cfg)
+	defer s.abort()
+	s.setHello(&ClientHello{ProtocolVersion: ProtocolVersion})
+
+	tests := []struct {
+		cmdType string
+		userID  string
+		code    string
+	}{
+		{"", "", CodeBadRequest},
+		{"nope", "", CodeUnknownCommand},
+		{"public", "", ""},
+		{"private", "", CodeUnauthorized},
+		{"private", primitive.NewObjectID().Hex(), ""},
+	}
+	for _, tt := range tests {
+		s.setUserID(tt.userID)
+		reply, later := r.Dispatch(s, Envelope{Type: tt.cmdType, RequestID: "1"})
+		if later != nil || reply.RequestID != "1" {
+			t.Fatalf("%q: reply %+v, deferred %v", tt.cmdType, reply, later != nil)
+		}
+		if tt.code == "" {
+			if !reply.OK || reply.Data != tt.cmdType {
+				t.Errorf("%q: reply = %+v, want the handler's", tt.cmdType, reply)
+			}
+		} else if reply.Error == nil || reply.Error.Code != tt.code {
+			t.Errorf("%q: error = %+v, want %s", tt.cmdType, reply.Error, tt.code)
+		}
+	}
+}
+
+func TestRouterRejectsDuplicateHandlers(t *testing.T) {
+	register := []func(r *Router, h HandlerFunc){
+		func(r *Router, h HandlerFunc) { r.Handle("cmd", h) },
+		func(r *Router, h HandlerFunc) { r.HandlePublic("cmd", h) },
+		func(r *Router, h HandlerFunc) { r.HandleHandshake("cmd", h) },
+	}
+	h := func(s *Session, env Envelope) (interface{}, error) { return nil, nil }
+	for i, first := range register {
+		for j, second := range register {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("registration %d after %d did not panic", j, i)
+					}
+				}()
+				r := NewRouter()
+				first(r, h)
+				second(r, h)
+			}()
+		}
+	}
+}
+
+func TestMalformedEnvelopes(t *testing.T) {
+	c := pipeClient(t, testServer(t, LoadConfig()), FrameModeLine)
+
+	for _, frame := range []string{`{"type":`, `[1,2]`, `"ping"`} {
+		c.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		must(t, c.framer.WriteFrame([]byte(frame)))
+		if r := c.next(nil); r.Error == nil || r.Error.Code != CodeBadRequest {
+			t.Errorf("%s: reply = %+v, want %s", frame, r, CodeBadRequest)
+		}
+	}
+
+	// The connection survives them.
+	if r := c.call("ping", "1", nil, nil); !r.OK {
+		t.Errorf("ping after malformed frames = %+v", r.Error)
+	}
+}
+
+func TestDeferredReplyDoesNotBlockTheReadLoop(t *testing.T) {
+	srv := NewServer(Dependencies{}, LoadConfig())
+	srv.router = NewRouter()
+
+	release := make(chan func(interface{}, error), 1)
+	srv.router.HandlePublic("slow", func(s *Session, env Envelope) (interface{}, error) {
+		return Deferred(func(reply func(interface{}, error)) { release <- reply }), nil
+	})
+	srv.router.HandlePublic("fast", func(s *Session, env Envelope) (interface{}, error) {
+		return "fast", nil
+	})
+
+	server, client := net.Pipe()
+	defer client.Close()
+	reader := bufio.NewReader(server)
+	session := newSession(server, &lineFramer{r: reader, w: server, maxSize: 1024}, srv.cfg)
+	session.setHello(&ClientHello{ProtocolVersion: ProtocolVersion})
+	go srv.serveSession(session)
+
+	replies := json.NewDecoder(client)
+	next := func() Reply {
+		t.Helper()
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var r Reply
+		if err := replies.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	client.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Write([]byte(`{"type":"slow","requestId":"1"}` + "\n" + `{"type":"fast","requestId":"2"}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r := next(); r.RequestID != "2" || !r.OK {
+		t.Fatalf("first reply = %+v, want the fast command's", r)
+	}
+
+	(<-release)("done", nil)
+	if r := next(); r.RequestID != "1" || r.Data != "done" {
+		t.Fatalf("deferred reply = %+v", r)
+	}
+}
@@ -0,0 +1,328 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"game_tcpserver/internal/game"
+)
+
+// Session holds the per-connection state shared by all handlers. TCP and
+// WebSocket clients are both served as sessions; Conn is the underlying
+// network connection used for deadlines and closing.
+type Session struct {
+	Conn net.Conn
+
+	framer         Framer
+	writeTimeout   time.Duration
+	queueSize      int
+	overflowPolicy string
+	clientName     string // set before the session is served, see ClientName
+
+	mu          sync.RWMutex
+	hello       *ClientHello
+	userID      string
+	resumeToken string
+	codec       Codec
+	players     map[string]*game.Player // roomID -> seat held by this session
+
+	// Only used by the read loop.
+	limits       sessionLimits
+	inCodec      Codec // decodes client frames
+	pendingCodec Codec // set by switchCodec, see applyCodecSwitch
+	throttled    bool  // disconnected by the rate limiter, see closeSession
+
+	// writeMu guards the outbound queues and everything below. Frames are
+	// written by a single writer goroutine, see writeLoop.
+	writeMu    sync.Mutex
+	stateQueue []outbound
+	chatQueue  []outbound
+	afterReply []func()
+	outbox     *outbox  // recent events, kept for resumption
+	replaying  bool     // events are only recorded until the replay is written
+	successor  *Session // the session that resumed this one
+
+	wake      chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	flushed   chan struct{} // closed once the writer has closed Conn
+}
+
+func newSession(conn net.Conn, framer Framer, cfg Config) *Session {
+	s := &Session{
+		Conn:           conn,
+		framer:         framer,
+		codec:          JSONCodec,
+		inCodec:        JSONCodec,
+		writeTimeout:   cfg.WriteTimeout,
+		queueSize:      cfg.OutboundQueueSize,
+		overflowPolicy: cfg.OverflowPolicy,
+		players:        make(map[string]*game.Player),
+		wake:           make(chan struct{}, 1),
+		done:           make(chan struct{}),
+		flushed:        make(chan struct{}),
+	}
+	go s.writeLoop()
+	return s
+}
+
+// Greeted reports whether the hello exchange has completed.
+func (s *Session) Greeted() bool {
+	return s.Hello() != nil
+}
+
+// Hello returns what the client announced in hello, or nil before it.
+func (s *Session) Hello() *ClientHello {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hello
+}
+
+func (s *Session) setHello(hello *ClientHello) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hello = hello
+}
+
+// UserID returns the authenticated user, or "" before the auth command.
+func (s *Session) UserID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.userID
+}
+
+// ClientName returns the CommonName of the verified TLS client
+// certificate, or "" when the client presented none.
+func (s *Session) ClientName() string {
+	return s.clientName
+}
+
+func (s *Session) Authenticated() bool {
+	return s.UserID() != ""
+}
+
+func (s *Session) setUserID(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userID = userID
+}
+
+// ResumeToken returns the token a client presents to resume this session
+// after a reconnect.
+func (s *Session) ResumeToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resumeToken
+}
+
+func (s *Session) setResumeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resumeToken = token
+}
+
+// Codec returns the codec of the frames sent to the client.
+func (s *Session) Codec() Codec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.codec
+}
+
+// switchCodec makes c take over in both directions. Client frames are
+// decoded with c from the next one on, which clients may send without
+// waiting for the reply; the reply is still written in the old codec, and
+// c takes over outbound frames and framing right after it. Only handlers,
+// running on the read loop, may call it.
+func (s *Session) switchCodec(c Codec) {
+	s.pendingCodec = c
+	s.onReplySent(func() {
+		s.mu.Lock()
+		s.codec = c
+		s.mu.Unlock()
+
+		if bf, ok := s.framer.(binaryFramer); ok {
+			bf.setBinary(c.Binary())
+		}
+	})
+}
+
+// applyCodecSwitch makes the codec chosen by the command just dispatched
+// decode the following frames. It is called by the read loop.
+func (s *Session) applyCodecSwitch() {
+	if s.pendingCodec != nil {
+		s.inCodec, s.pendingCodec = s.pendingCodec, nil
+	}
+}
+
+// closeAfter closes the session once the reply of the current command has
+// been written.
+func (s *Session) closeAfter() {
+	s.onReplySent(s.Close)
+}
+
+// onReplySent runs fn on the writer goroutine right after the reply of the
+// current command is written, before any other frame. fn may write directly
+// with write.
+func (s *Session) onReplySent(fn func()) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.afterReply = append(s.afterReply, fn)
+}
+
+// joinedRoom records the seat this session took in roomID so it can be
+// released when the connection goes away.
+func (s *Session) joinedRoom(roomID string, p *game.Player) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.players[roomID] = p
+}
+
+// leftRoom forgets the seat in roomID.
+func (s *Session) leftRoom(roomID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.players, roomID)
+}
+
+// roomSeat returns the seat this session holds in roomID, or nil.
+func (s *Session) roomSeat(roomID string) *game.Player {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.players[roomID]
+}
+
+// roomSeats returns a snapshot of the rooms this session sits in.
+func (s *Session) roomSeats() map[string]*game.Player {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seats := make(map[string]*game.Player, len(s.players))
+	for roomID, p := range s.players {
+		seats[roomID] = p
+	}
+	return seats
+}
+
+// Send queues a Reply or Event for the client. Events are numbered and kept
+// in the outbox once the session is authenticated, so a closed session keeps
+// recording them until it is resumed or expires. Send never blocks on the
+// network; a full queue is handled by the overflow policy.
+func (s *Session) Send(v interface{}) error {
+	s.writeMu.Lock()
+
+	if s.successor != nil {
+		next := s.successor
+		s.writeMu.Unlock()
+		return next.Send(v)
+	}
+
+	if ev, ok := v.(Event); ok && s.outbox != nil {
+		v = s.outbox.push(ev)
+		if s.replaying || s.closed() {
+			s.writeMu.Unlock()
+			return nil
+		}
+	}
+
+	return s.enqueue(outbound{v: v})
+}
+
+// sendTransient queues ev without numbering or recording it. It is used for
+// heartbeats and state snapshots, which are meaningless after a reconnect.
+func (s *Session) sendTransient(ev Event) error {
+	s.writeMu.Lock()
+
+	if s.successor != nil {
+		next := s.successor
+		s.writeMu.Unlock()
+		return next.sendTransient(ev)
+	}
+
+	return s.enqueue(outbound{v: ev})
+}
+
+// SendEvent implements game.Conn. Room events are numbered and kept for
+// replay like any other event.
+func (s *Session) SendEvent(eventType string, data interface{}) error {
+	return s.Send(Event{Type: eventType, Data: data})
+}
+
+// SendRoomState implements game.Conn. Snapshots travel in the state lane
+// and are not kept for replay.
+func (s *Session) SendRoomState(snapshot game.RoomSnapshot) error {
+	return s.sendTransient(Event{Type: EventRoomState, Data: snapshot})
+}
+
+// SendRoomDelta implements game.Conn like SendRoomState.
+func (s *Session) SendRoomDelta(delta game.RoomDelta) error {
+	return s.sendTransient(Event{Type: EventRoomDelta, Data: delta})
+}
+
+// sendReply queues the reply of a command together with the hooks the
+// command registered, so no other frame can slip in between.
+func (s *Session) sendReply(r Reply) error {
+	s.writeMu.Lock()
+	o := outbound{v: r, after: s.afterReply}
+	s.afterReply = nil
+	return s.enqueue(o)
+}
+
+// enqueue is called with writeMu held and releases it.
+func (s *Session) enqueue(o outbound) error {
+	if s.closed() {
+		s.writeMu.Unlock()
+		return net.ErrClosed
+	}
+
+	ok := s.enqueueLocked(o)
+	s.writeMu.Unlock()
+
+	if !ok {
+		s.evictSlowConsumer()
+		return ErrSlowConsumer
+	}
+	return nil
+}
+
+// Close ends the session: the read loop stops and the writer flushes what
+// is already queued before closing the connection. It is safe to call more
+// than once.
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.Conn.SetReadDeadline(time.Now())
+	})
+}
+
+// abort closes the connection right away, dropping queued frames.
+func (s *Session) abort() {
+	s.Close()
+
+	s.writeMu.Lock()
+	s.drainLocked()
+	s.writeMu.Unlock()
+
+	s.Conn.Close()
+}
+
+// Done is closed once the session has been closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// superseded reports whether another session resumed this one.
+func (s *Session) superseded() bool {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.successor != nil
+}
+
+func (s *Session) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
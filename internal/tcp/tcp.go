@@ -1,25 +1,308 @@
 package tcp
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+
+	"game_tcpserver/internal/game"
 )
 
-func StartTCPServer(deps Dependencies) {
-	ln, err := net.Listen("tcp", ":9090")
+// Server owns the command router and the connection hub shared by every
+// client connection. Start opens its listeners and Shutdown stops it.
+type Server struct {
+	cfg     Config
+	deps    Dependencies
+	router  *Router
+	hub     *Hub
+	resumes *resumeRegistry
+	tls     *tlsReloader // nil without TLS
+	limiter *rateLimiter
+	matches *game.Matchmaker
+	parties *parties
+
+	mu           sync.Mutex
+	listeners    []net.Listener
+	sessions     map[*Session]struct{}
+	shuttingDown bool
+
+	accepting sync.WaitGroup // accept loops
+	serving   sync.WaitGroup // session read loops
+	inflight  sync.WaitGroup // commands being dispatched
+}
+
+func NewServer(deps Dependencies, cfg Config) *Server {
+	if deps.Rooms == nil {
+		deps.Rooms = game.NewRoomManager(game.DefaultRules)
+	}
+
+	srv := &Server{
+		cfg:      cfg,
+		deps:     deps,
+		router:   NewRouter(),
+		hub:      NewHub(),
+		sessions: make(map[*Session]struct{}),
+		limiter:  newRateLimiter(cfg),
+	}
+	srv.resumes = newResumeRegistry(srv.releaseSession)
+	srv.matches = game.NewMatchmaker(game.DefaultMatchmakerConfig, deps.Rooms, deps.Ratings, srv.matchFound)
+	srv.parties = newParties(srv.hub, srv.matches)
+
+	h := &commandHandlers{
+		deps:       deps,
+		hub:        srv.hub,
+		cfg:        cfg,
+		resumes:    srv.resumes,
+		matchmaker: srv.matches,
+		parties:    srv.parties,
+	}
+	h.register(srv.router)
+
+	return srv
+}
+
+// Start listens on Addr, where each connection picks its framing with the
+// handshake byte, on BinaryAddr, when set, where connections always use
+// length-prefixed framing, and on BotAddr, when set, which only accepts
+// clients with a certificate. Connections are served in the background.
+// All serve TLS when it is configured. WebSocket clients reach the same
+// server through ServeWebSocket.
+func (srv *Server) Start() error {
+	if srv.cfg.BotAddr != "" && !srv.cfg.TLSEnabled() {
+		return errors.New("tcp: TCP_BOT_ADDR needs TLS with TCP_TLS_CLIENT_CA_FILE")
+	}
+	if srv.cfg.TLSEnabled() {
+		reloader, err := newTLSReloader(srv.cfg)
+		if err != nil {
+			return err
+		}
+		srv.tls = reloader
+	}
+
+	if err := srv.listen(srv.cfg.Addr, nil, false); err != nil {
+		return err
+	}
+
+	if srv.cfg.BinaryAddr != "" {
+		mode := FrameModeLengthPrefixed
+		if err := srv.listen(srv.cfg.BinaryAddr, &mode, false); err != nil {
+			srv.closeListeners()
+			return err
+		}
+	}
+
+	if srv.cfg.BotAddr != "" {
+		if err := srv.listen(srv.cfg.BotAddr, nil, true); err != nil {
+			srv.closeListeners()
+			return err
+		}
+	}
+
+	srv.matches.Start()
+	return nil
+}
+
+// matchFound pushes a match made by the matchmaker to its players.
+func (srv *Server) matchFound(match game.Match) {
+	srv.hub.SendToUsers(match.PlayerIDs, Event{Type: EventMatchFound, Data: match}, nil)
+}
+
+// listen serves addr; with requireCert, TLS clients must present a
+// certificate signed by TLSClientCAFile.
+func (srv *Server) listen(addr string, forcedMode *FrameMode, requireCert bool) error {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		panic(fmt.Sprintf("TCP server failed: %v", err))
+		return fmt.Errorf("tcp listen on %s: %w", addr, err)
+	}
+	if srv.tls != nil {
+		clientAuth := srv.tls.clientAuth
+		if requireCert {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+		ln = tls.NewListener(ln, srv.tls.listenerConfig(clientAuth))
 	}
 
-	fmt.Println("TCP server running on port 9090")
+	srv.mu.Lock()
+	srv.listeners = append(srv.listeners, ln)
+	srv.mu.Unlock()
+
+	if srv.tls != nil {
+		fmt.Println("TCP server running with TLS on", ln.Addr())
+	} else {
+		fmt.Println("TCP server running on", ln.Addr())
+	}
+
+	srv.accepting.Add(1)
+	go srv.acceptLoop(ln, forcedMode)
+	return nil
+}
+
+// Addrs returns the addresses the server is listening on.
+func (srv *Server) Addrs() []net.Addr {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(srv.listeners))
+	for _, ln := range srv.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+func (srv *Server) acceptLoop(ln net.Listener, forcedMode *FrameMode) {
+	defer srv.accepting.Done()
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Println("Connection error:", err)
 			continue
 		}
 
-		go HandleConnection(conn, deps)
+		go srv.handleConnection(conn, forcedMode)
+	}
+}
+
+func (srv *Server) closeListeners() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for _, ln := range srv.listeners {
+		ln.Close()
+	}
+	srv.listeners = nil
+}
+
+// trackSession records a session so Shutdown can close it. It reports
+// false once the server is shutting down.
+func (srv *Server) trackSession(s *Session) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.shuttingDown {
+		return false
+	}
+	srv.sessions[s] = struct{}{}
+	srv.serving.Add(1)
+	return true
+}
+
+func (srv *Server) untrackSession(s *Session) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if _, ok := srv.sessions[s]; ok {
+		delete(srv.sessions, s)
+		srv.serving.Done()
+	}
+}
+
+// beginCommand reports whether a command may still be dispatched. When it
+// returns true the caller must call srv.inflight.Done afterwards.
+func (srv *Server) beginCommand() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.shuttingDown {
+		return false
+	}
+	srv.inflight.Add(1)
+	return true
+}
+
+func (srv *Server) isShuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.shuttingDown
+}
+
+func (srv *Server) liveSessions() []*Session {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(srv.sessions))
+	for s := range srv.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// shutdownError is returned for commands received during Shutdown.
+func (srv *Server) shutdownError() *CommandError {
+	return &CommandError{
+		Code:    CodeShuttingDown,
+		Message: "server is shutting down",
+		Details: map[string]interface{}{
+			"reconnectAfterMs": srv.cfg.ShutdownReconnectHint.Milliseconds(),
+		},
+	}
+}
+
+// Shutdown stops accepting connections, sends every client a
+// server.shutdown event with a reconnect hint, waits for the commands being
+// handled, ends the matches still running and closes the connections.
+// Connections still open when ctx expires are dropped.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.shuttingDown = true
+	srv.mu.Unlock()
+
+	srv.closeListeners()
+	srv.accepting.Wait()
+	srv.matches.Close()
+
+	notice := Event{Type: EventServerShutdown, Data: map[string]interface{}{
+		"reason":           "server is shutting down",
+		"reconnectAfterMs": srv.cfg.ShutdownReconnectHint.Milliseconds(),
+	}}
+	for _, s := range srv.liveSessions() {
+		s.sendTransient(notice)
+	}
+
+	var errs []error
+	if err := waitContext(ctx, srv.inflight.Wait); err != nil {
+		errs = append(errs, fmt.Errorf("waiting for in-flight commands: %w", err))
+	}
+
+	// Matches still running end here, before their players disconnect,
+	// and are recorded by the Rooms' OnMatchEnd.
+	if err := waitContext(ctx, srv.deps.Rooms.Close); err != nil {
+		errs = append(errs, fmt.Errorf("recording matches: %w", err))
+	}
+
+	// Each writer flushes what is queued, the notice included, before
+	// closing its connection.
+	for _, s := range srv.liveSessions() {
+		s.Close()
+	}
+	if err := waitContext(ctx, srv.serving.Wait); err != nil {
+		for _, s := range srv.liveSessions() {
+			s.abort()
+		}
+		errs = append(errs, fmt.Errorf("closing connections: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// waitContext waits for wait to return until ctx is done.
+func waitContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
@@ -0,0 +1,80 @@
+package tcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestShutdownDrainsInFlightCommands(t *testing.T) {
+	srv := testServer(t, LoadConfig())
+	release := make(chan func(interface{}, error), 1)
+	srv.router.HandlePublic("slow", func(s *Session, env Envelope) (interface{}, error) {
+		return Deferred(func(reply func(interface{}, error)) { release <- reply }), nil
+	})
+
+	c := pipeClient(t, srv, FrameModeLine)
+	c.hello()
+	c.send("slow", "1", nil)
+	var reply func(interface{}, error)
+	select {
+	case reply = <-release:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the slow command never started")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Shutdown(ctx) }()
+
+	if f := c.next(nil); f.Type != EventServerShutdown {
+		t.Fatalf("first frame = %+v, want the shutdown notice", f)
+	}
+	if r := c.call("ping", "2", nil, nil); r.Error == nil || r.Error.Code != CodeShuttingDown {
+		t.Errorf("command during shutdown = %+v, want %s", r, CodeShuttingDown)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Shutdown returned with a command in flight: %v", err)
+	default:
+	}
+
+	reply("done", nil)
+	var data string
+	if r := c.next(&data); r.RequestID != "1" || !r.OK || data != "done" {
+		t.Errorf("deferred reply = %+v, %q", r, data)
+	}
+	if !c.closed(2 * time.Second) {
+		t.Error("connection still open after Shutdown")
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
+
+func TestShutdownForgetsResumeTokens(t *testing.T) {
+	srv := testServer(t, LoadConfig())
+	c := pipeClient(t, srv, FrameModeLine)
+	c.hello()
+	c.auth(primitive.NewObjectID().Hex())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Shutdown(ctx) }()
+	if !c.closed(2 * time.Second) {
+		t.Fatal("connection still open after Shutdown")
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+
+	srv.resumes.mu.Lock()
+	defer srv.resumes.mu.Unlock()
+	if n := len(srv.resumes.sessions); n != 0 {
+		t.Errorf("%d sessions still resumable after Shutdown", n)
+	}
+}
@@ -0,0 +1,203 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Client certificate policies for TLSClientAuth. Certificates are never
+// required there, since regular players have none; BotAddr is the
+// listener that requires them.
+const (
+	// ClientAuthNone does not ask clients for a certificate.
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies a certificate when the client sends one,
+	// which lets internal bots identify themselves next to regular clients.
+	ClientAuthOptional = "optional"
+)
+
+// tlsReloader serves the certificate and client CA pool from disk and
+// picks up replaced files on the next handshake, at most once per
+// TLSReloadInterval, so certificates can be rotated without a restart.
+type tlsReloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType // on Addr and BinaryAddr
+	interval   time.Duration
+
+	mu      sync.Mutex
+	configs map[tls.ClientAuthType]*tls.Config
+	stamps  []fileStamp
+	checked time.Time
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newTLSReloader(cfg Config) (*tlsReloader, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("tls: both TCP_TLS_CERT_FILE and TCP_TLS_KEY_FILE must be set")
+	}
+
+	r := &tlsReloader{
+		certFile: cfg.TLSCertFile,
+		keyFile:  cfg.TLSKeyFile,
+		caFile:   cfg.TLSClientCAFile,
+		interval: cfg.TLSReloadInterval,
+	}
+
+	switch cfg.TLSClientAuth {
+	case "", ClientAuthNone:
+		r.clientAuth = tls.NoClientCert
+	case ClientAuthOptional:
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("tls: unknown client auth policy %q, want %q or %q (certificates are required on TCP_BOT_ADDR)", cfg.TLSClientAuth, ClientAuthNone, ClientAuthOptional)
+	}
+	if (r.clientAuth != tls.NoClientCert || cfg.BotAddr != "") && r.caFile == "" {
+		return nil, errors.New("tls: client certificates need TCP_TLS_CLIENT_CA_FILE")
+	}
+
+	configs, stamps, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.configs = configs
+	r.stamps = stamps
+	r.checked = time.Now()
+	return r, nil
+}
+
+// listenerConfig is the config given to tls.NewListener for a listener
+// with the given client certificate policy; every handshake asks the
+// reloader for the current certificates.
+func (r *tlsReloader) listenerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.configFor(clientAuth), nil
+		},
+	}
+}
+
+func (r *tlsReloader) configFor(clientAuth tls.ClientAuthType) *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) < r.interval {
+		return r.configs[clientAuth]
+	}
+	r.checked = time.Now()
+
+	stamps, err := r.stat()
+	if err != nil || stampsEqual(stamps, r.stamps) {
+		return r.configs[clientAuth]
+	}
+
+	// A half-written rotation fails to load; keep serving the old
+	// certificates and retry on a later handshake.
+	configs, stamps, err := r.load()
+	if err != nil {
+		fmt.Println("TLS reload failed, keeping the current certificates:", err)
+		return r.configs[clientAuth]
+	}
+	r.configs = configs
+	r.stamps = stamps
+	fmt.Println("TLS certificates reloaded")
+	return r.configs[clientAuth]
+}
+
+func (r *tlsReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+func (r *tlsReloader) stat() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, name := range r.files() {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+func stampsEqual(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// load reads the files and returns a config per client certificate
+// policy, with the stamps taken before reading them, so a change during
+// the read is seen next time. The policy requiring certificates is only
+// there when a client CA is configured.
+func (r *tlsReloader) load() (map[tls.ClientAuthType]*tls.Config, []fileStamp, error) {
+	stamps, err := r.stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("tls: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tls: loading key pair: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls: reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("tls: no certificates in %s", r.caFile)
+		}
+		config.ClientCAs = pool
+	}
+
+	configs := make(map[tls.ClientAuthType]*tls.Config)
+	policies := []tls.ClientAuthType{r.clientAuth}
+	if config.ClientCAs != nil {
+		policies = append(policies, tls.RequireAndVerifyClientCert)
+	}
+	for _, policy := range policies {
+		c := config.Clone()
+		c.ClientAuth = policy
+		configs[policy] = c
+	}
+	return configs, stamps, nil
+}
+
+// verifiedClientName returns the CommonName of the client certificate
+// verified against TLSClientCAFile, or "" when the client sent none.
+func verifiedClientName(conn *tls.Conn) string {
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.CommonName
+}
@@ -0,0 +1,299 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testCert is a self-signed certificate written to disk for the server or
+// a client.
+type testCert struct {
+	certFile string
+	keyFile  string
+	cert     *x509.Certificate
+	pair     tls.Certificate
+}
+
+func writeSelfSignedCert(t *testing.T, dir, name string, serial int64) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	tc := testCert{
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+		cert:     cert,
+	}
+	if err := os.WriteFile(tc.certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tc.keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tc.pair, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+func startTLSServer(t *testing.T, cfg Config) string {
+	t.Helper()
+
+	cfg.Addr = "127.0.0.1:0"
+	cfg.BinaryAddr = ""
+	srv := NewServer(Dependencies{}, cfg)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	return srv.Addrs()[0].String()
+}
+
+// helloOverTLS dials addr, sends hello on line framing and returns the
+// certificate the server presented.
+func helloOverTLS(t *testing.T, addr string, clientConfig *tls.Config) *x509.Certificate {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte(`{"type":"hello","requestId":"1","payload":{"protocolVersion":1}}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply Reply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.OK || reply.RequestID != "1" {
+		t.Fatalf("hello over TLS failed: %s", line)
+	}
+
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func rootsFor(certs ...testCert) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c.cert)
+	}
+	return pool
+}
+
+func TestTLSListenerServesCommands(t *testing.T) {
+	dir := t.TempDir()
+	server := writeSelfSignedCert(t, dir, "server", 1)
+
+	cfg := LoadConfig()
+	cfg.TLSCertFile = server.certFile
+	cfg.TLSKeyFile = server.keyFile
+	addr := startTLSServer(t, cfg)
+
+	got := helloOverTLS(t, addr, &tls.Config{RootCAs: rootsFor(server)})
+	if got.SerialNumber.Cmp(server.cert.SerialNumber) != 0 {
+		t.Errorf("server presented serial %v, want %v", got.SerialNumber, server.cert.SerialNumber)
+	}
+
+	// A plaintext client never gets a reply.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(`{"type":"hello","requestId":"1","payload":{"protocolVersion":1}}` + "\n"))
+	if line, err := bufio.NewReader(conn).ReadBytes('\n'); err == nil {
+		t.Errorf("plaintext client got a reply: %s", line)
+	}
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	first := writeSelfSignedCert(t, dir, "server", 1)
+
+	cfg := LoadConfig()
+	cfg.TLSCertFile = first.certFile
+	cfg.TLSKeyFile = first.keyFile
+	cfg.TLSReloadInterval = time.Nanosecond
+	addr := startTLSServer(t, cfg)
+
+	rotated := writeSelfSignedCert(t, t.TempDir(), "server", 2)
+	roots := rootsFor(first, rotated)
+
+	if got := helloOverTLS(t, addr, &tls.Config{RootCAs: roots}); got.SerialNumber.Int64() != 1 {
+		t.Fatalf("serial before rotation = %v, want 1", got.SerialNumber)
+	}
+
+	// Rotate the files in place, as a certificate manager would.
+	for src, dst := range map[string]string{rotated.certFile: first.certFile, rotated.keyFile: first.keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(dst, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := helloOverTLS(t, addr, &tls.Config{RootCAs: roots}); got.SerialNumber.Int64() != 2 {
+		t.Fatalf("serial after rotation = %v, want 2", got.SerialNumber)
+	}
+}
+
+func TestTLSBotListenerRequiresClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	server := writeSelfSignedCert(t, dir, "server", 1)
+	botID := primitive.NewObjectID().Hex()
+	bot := writeSelfSignedCert(t, dir, botID, 2)
+
+	cfg := LoadConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.BinaryAddr = ""
+	cfg.BotAddr = "127.0.0.1:0"
+	cfg.TLSCertFile = server.certFile
+	cfg.TLSKeyFile = server.keyFile
+	cfg.TLSClientCAFile = bot.certFile
+	srv := NewServer(Dependencies{}, cfg)
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	playerAddr, botAddr := srv.Addrs()[0].String(), srv.Addrs()[1].String()
+
+	// Players keep connecting without a certificate.
+	helloOverTLS(t, playerAddr, &tls.Config{RootCAs: rootsFor(server)})
+
+	// The bot authenticates with its certificate instead of a token.
+	conn, err := tls.Dial("tcp", botAddr, &tls.Config{
+		RootCAs:      rootsFor(server),
+		Certificates: []tls.Certificate{bot.pair},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(`{"type":"hello","requestId":"1","payload":{"protocolVersion":1}}` + "\n"))
+	conn.Write([]byte(`{"type":"auth","requestId":"2","payload":{}}` + "\n"))
+
+	reader := bufio.NewReader(conn)
+	reader.ReadBytes('\n')
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		OK   bool `json:"ok"`
+		Data struct {
+			UserID string `json:"userId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(line, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !reply.OK || reply.Data.UserID != botID {
+		t.Errorf("bot auth = %s, want user %s", line, botID)
+	}
+
+	// Without a certificate the handshake fails; with TLS 1.3 the client
+	// only learns it on the first read.
+	conn, err = tls.Dial("tcp", botAddr, &tls.Config{RootCAs: rootsFor(server)})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(`{"type":"hello","requestId":"1","payload":{"protocolVersion":1}}` + "\n"))
+	if line, err := bufio.NewReader(conn).ReadBytes('\n'); err == nil {
+		t.Errorf("client without a certificate got a reply: %s", line)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	server := writeSelfSignedCert(t, dir, "server", 1)
+
+	tests := map[string]Config{
+		"key missing":         {TLSCertFile: server.certFile},
+		"unreadable files":    {TLSCertFile: filepath.Join(dir, "nope.crt"), TLSKeyFile: server.keyFile},
+		"optional without CA": {TLSCertFile: server.certFile, TLSKeyFile: server.keyFile, TLSClientAuth: ClientAuthOptional},
+		"bot without CA":      {TLSCertFile: server.certFile, TLSKeyFile: server.keyFile, BotAddr: "127.0.0.1:0"},
+		"bot without TLS":     {BotAddr: "127.0.0.1:0"},
+		"required for all":    {TLSCertFile: server.certFile, TLSKeyFile: server.keyFile, TLSClientAuth: "require"},
+		"unknown policy":      {TLSCertFile: server.certFile, TLSKeyFile: server.keyFile, TLSClientAuth: "sometimes"},
+	}
+	for name, cfg := range tests {
+		cfg.Addr = "127.0.0.1:0"
+		if err := NewServer(Dependencies{}, cfg).Start(); err == nil {
+			t.Errorf("%s: Start succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ServeWebSocket upgrades an HTTP request and serves it as a session with
+// the same command set, hub and rooms as TCP clients. Each WebSocket
+// message carries exactly one frame.
+func (srv *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	if srv.isShuttingDown() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin:     srv.checkOrigin,
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response.
+		fmt.Println("WebSocket upgrade failed:", r.RemoteAddr, err)
+		return
+	}
+
+	ws.SetReadLimit(int64(srv.cfg.MaxFrameSize))
+
+	conn := ws.NetConn()
+	conn.SetReadDeadline(time.Now().Add(srv.cfg.AuthTimeout))
+
+	srv.serveSession(newSession(conn, &wsFramer{ws: ws}, srv.cfg))
+}
+
+// checkOrigin accepts non-browser clients, which send no Origin header,
+// and browsers on an allowed origin.
+func (srv *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range srv.cfg.WSAllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// binaryFramer is implemented by framers that must know whether the
+// session codec produces binary data.
+type binaryFramer interface {
+	setBinary(binary bool)
+}
+
+// wsFramer adapts a WebSocket connection to Framer. Frames are sent as
+// text messages for JSON and as binary messages for binary codecs.
+type wsFramer struct {
+	ws     *websocket.Conn
+	binary bool
+}
+
+func (f *wsFramer) Mode() FrameMode { return FrameModeWebSocket }
+
+func (f *wsFramer) setBinary(binary bool) { f.binary = binary }
+
+func (f *wsFramer) ReadFrame() ([]byte, error) {
+	_, data, err := f.ws.ReadMessage()
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return nil, ErrFrameTooLarge
+	}
+	return data, err
+}
+
+func (f *wsFramer) WriteFrame(payload []byte) error {
+	messageType := websocket.TextMessage
+	if f.binary {
+		messageType = websocket.BinaryMessage
+	}
+	return f.ws.WriteMessage(messageType, payload)
+}
@@ -0,0 +1,71 @@
+package tcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"game_tcpserver/internal/game"
+)
+
+// wsClient connects a client to srv through its WebSocket gateway.
+func wsClient(t *testing.T, srv *Server) *testClient {
+	t.Helper()
+
+	httpSrv := httptest.NewServer(http.HandlerFunc(srv.ServeWebSocket))
+	t.Cleanup(httpSrv.Close)
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpSrv.URL, "http"), nil)
+	must(t, err)
+	t.Cleanup(func() { ws.Close() })
+	return &testClient{t: t, conn: ws.NetConn(), framer: &wsFramer{ws: ws}, codec: JSONCodec}
+}
+
+func TestTCPAndWebSocketClientsShareRooms(t *testing.T) {
+	srv := testServer(t, LoadConfig())
+	tcpUser, wsUser := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+
+	tcp := pipeClient(t, srv, FrameModeLine)
+	tcp.hello()
+	tcp.auth(tcpUser)
+	ws := wsClient(t, srv)
+	ws.hello()
+	ws.auth(wsUser)
+
+	for _, c := range []*testClient{tcp, ws} {
+		if r := c.call("create_room", "join", TCPRoom{RoomID: "shared", PlayerName: "p"}, nil); !r.OK {
+			t.Fatalf("join: %+v", r.Error)
+		}
+	}
+
+	// Each sees the other get ready, whichever transport they are on.
+	tests := []struct {
+		from, to *testClient
+		userID   string
+	}{
+		{tcp, ws, tcpUser},
+		{ws, tcp, wsUser},
+	}
+	for _, tt := range tests {
+		if r := tt.from.call("ready", "ready", TCPReady{RoomID: "shared", Ready: true}, nil); !r.OK {
+			t.Fatalf("ready: %+v", r.Error)
+		}
+		var ready game.PlayerReady
+		tt.to.event(game.EventPlayerReady, &ready)
+		if ready.PlayerID != tt.userID || !ready.Ready {
+			t.Errorf("room.ready = %+v, want %s ready", ready, tt.userID)
+		}
+	}
+
+	if r := ws.call("leave_room", "leave", TCPLeaveRoom{RoomID: "shared"}, nil); !r.OK {
+		t.Fatalf("leave: %+v", r.Error)
+	}
+	var left game.PlayerLeft
+	tcp.event(game.EventPlayerLeft, &left)
+	if left.PlayerID != wsUser {
+		t.Errorf("room.leave = %+v, want %s", left, wsUser)
+	}
+}
@@ -0,0 +1,182 @@
+package tcp
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+)
+
+// Overflow policies for a full outbound queue.
+const (
+	// OverflowDropOldest drops the oldest queued state snapshot to make
+	// room; clients catch up with the next one. A full chat lane still
+	// disconnects since replies and chat events must not be lost.
+	OverflowDropOldest = "drop_oldest"
+	// OverflowDisconnect disconnects the slow consumer on any overflow.
+	OverflowDisconnect = "disconnect"
+)
+
+// ErrSlowConsumer is returned by Send when the session was disconnected
+// because it could not keep up with its outbound queue.
+var ErrSlowConsumer = errors.New("slow consumer disconnected")
+
+type lane int
+
+const (
+	// laneState carries game state snapshots. It is written first and may
+	// drop frames under the drop_oldest policy.
+	laneState lane = iota
+	// laneChat carries replies, chat and every other event, losslessly.
+	laneChat
+)
+
+// outbound holds a frame waiting for the writer goroutine. after runs once
+// the frame is written, see Session.onReplySent.
+type outbound struct {
+	v     interface{}
+	after []func()
+}
+
+var (
+	outboundMetrics = expvar.NewMap("tcp_outbound")
+
+	queuedState       = new(expvar.Int)
+	queuedChat        = new(expvar.Int)
+	droppedState      = new(expvar.Int)
+	slowConsumerEvict = new(expvar.Int)
+)
+
+func init() {
+	outboundMetrics.Set("queue_depth_state", queuedState)
+	outboundMetrics.Set("queue_depth_chat", queuedChat)
+	outboundMetrics.Set("dropped_state", droppedState)
+	outboundMetrics.Set("slow_consumer_disconnects", slowConsumerEvict)
+}
+
+func laneFor(v interface{}) lane {
+	if ev, ok := v.(Event); ok && (ev.Type == EventRoomState || ev.Type == EventRoomDelta) {
+		return laneState
+	}
+	return laneChat
+}
+
+// enqueueLocked queues o for the writer. It reports false when the session
+// must be disconnected as a slow consumer. writeMu must be held.
+func (s *Session) enqueueLocked(o outbound) bool {
+	l := laneFor(o.v)
+	queue := &s.chatQueue
+	depth := queuedChat
+	if l == laneState {
+		queue = &s.stateQueue
+		depth = queuedState
+	}
+
+	if len(*queue) >= s.queueSize {
+		if l != laneState || s.overflowPolicy != OverflowDropOldest {
+			return false
+		}
+		*queue = (*queue)[1:]
+		depth.Add(-1)
+		droppedState.Add(1)
+	}
+
+	*queue = append(*queue, o)
+	depth.Add(1)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// QueueDepth returns the number of frames waiting in each lane.
+func (s *Session) QueueDepth() (state, chat int) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return len(s.stateQueue), len(s.chatQueue)
+}
+
+// nextLocked pops the next frame, state snapshots first: a snapshot
+// overtakes the events queued before it, those of its own tick included.
+func (s *Session) nextLocked() (outbound, bool) {
+	if len(s.stateQueue) > 0 {
+		o := s.stateQueue[0]
+		s.stateQueue = s.stateQueue[1:]
+		queuedState.Add(-1)
+		return o, true
+	}
+	if len(s.chatQueue) > 0 {
+		o := s.chatQueue[0]
+		s.chatQueue = s.chatQueue[1:]
+		queuedChat.Add(-1)
+		return o, true
+	}
+	return outbound{}, false
+}
+
+// writeLoop is the only goroutine writing to the connection. After Close it
+// flushes what is queued, bounded by the write timeout, and closes Conn.
+func (s *Session) writeLoop() {
+	defer close(s.flushed)
+	defer s.Conn.Close()
+
+	for {
+		s.writeMu.Lock()
+		o, ok := s.nextLocked()
+		s.writeMu.Unlock()
+
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				// Pick up anything queued right before Close.
+				s.writeMu.Lock()
+				o, ok = s.nextLocked()
+				s.writeMu.Unlock()
+				if !ok {
+					return
+				}
+			}
+		}
+
+		if err := s.write(o.v); err != nil {
+			fmt.Println("Write error:", s.Conn.RemoteAddr(), err)
+			s.abort()
+			return
+		}
+		for _, fn := range o.after {
+			fn()
+		}
+	}
+}
+
+// write encodes and writes v. Only the writer goroutine calls it.
+func (s *Session) write(v interface{}) error {
+	data, err := s.Codec().Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if s.writeTimeout > 0 {
+		s.Conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+	return s.framer.WriteFrame(data)
+}
+
+// evictSlowConsumer disconnects a session whose queue overflowed.
+func (s *Session) evictSlowConsumer() {
+	slowConsumerEvict.Add(1)
+	fmt.Println("Slow consumer disconnected:", s.Conn.RemoteAddr())
+	s.abort()
+}
+
+// drainLocked discards everything still queued. writeMu must be held.
+func (s *Session) drainLocked() {
+	queuedState.Add(-int64(len(s.stateQueue)))
+	queuedChat.Add(-int64(len(s.chatQueue)))
+	s.stateQueue = nil
+	s.chatQueue = nil
+}
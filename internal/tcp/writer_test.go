@@ -0,0 +1,71 @@
+package tcp
+
+import (
+	"reflect"
+	"testing"
+
+	"game_tcpserver/internal/game"
+)
+
+func stateEvent(tick uint64) outbound {
+	return outbound{v: Event{Type: EventRoomState, Data: game.RoomSnapshot{Tick: tick}}}
+}
+
+func TestEnqueueOverflowPolicies(t *testing.T) {
+	s := &Session{queueSize: 2, overflowPolicy: OverflowDropOldest, wake: make(chan struct{}, 1)}
+
+	dropped := droppedState.Value()
+	for tick := uint64(1); tick <= 3; tick++ {
+		if !s.enqueueLocked(stateEvent(tick)) {
+			t.Fatalf("drop_oldest: state frame %d disconnected", tick)
+		}
+	}
+	if len(s.stateQueue) != 2 || s.stateQueue[0].v.(Event).Data.(game.RoomSnapshot).Tick != 2 {
+		t.Errorf("drop_oldest: state lane = %+v, want ticks 2 and 3", s.stateQueue)
+	}
+	if got := droppedState.Value() - dropped; got != 1 {
+		t.Errorf("dropped_state grew by %d, want 1", got)
+	}
+
+	// Replies and other events are never dropped.
+	s.enqueueLocked(outbound{v: Reply{RequestID: "1", OK: true}})
+	s.enqueueLocked(outbound{v: Event{Type: EventMessageNew}})
+	if s.enqueueLocked(outbound{v: Event{Type: EventMessageNew}}) {
+		t.Error("drop_oldest: a full chat lane did not disconnect")
+	}
+	if len(s.chatQueue) != 2 {
+		t.Errorf("drop_oldest: chat lane holds %d frames, want 2", len(s.chatQueue))
+	}
+
+	s = &Session{queueSize: 2, overflowPolicy: OverflowDisconnect, wake: make(chan struct{}, 1)}
+	s.enqueueLocked(stateEvent(1))
+	s.enqueueLocked(outbound{v: Event{Type: EventRoomDelta, Data: game.RoomDelta{Tick: 2}}})
+	if s.enqueueLocked(stateEvent(3)) {
+		t.Error("disconnect: a full state lane did not disconnect")
+	}
+	if len(s.stateQueue) != 2 || len(s.chatQueue) != 0 {
+		t.Errorf("disconnect: lanes hold %d state and %d chat frames, want 2 and 0", len(s.stateQueue), len(s.chatQueue))
+	}
+}
+
+func TestSnapshotsOvertakeQueuedEvents(t *testing.T) {
+	s := &Session{queueSize: 4, overflowPolicy: OverflowDropOldest, wake: make(chan struct{}, 1)}
+
+	// A tick hands its events to the session before its snapshot.
+	s.enqueueLocked(outbound{v: Event{Type: game.EventRoomTransition}})
+	s.enqueueLocked(outbound{v: Event{Type: game.EventPlayerLeft}})
+	s.enqueueLocked(stateEvent(7))
+
+	var got []string
+	for {
+		o, ok := s.nextLocked()
+		if !ok {
+			break
+		}
+		got = append(got, o.v.(Event).Type)
+	}
+	want := []string{EventRoomState, game.EventRoomTransition, game.EventPlayerLeft}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("written in order %v, want %v", got, want)
+	}
+}